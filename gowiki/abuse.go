@@ -0,0 +1,373 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// When anybody may edit the wiki, every save made for a user goes through a
+// few controls, whether it comes from the edit form, the API, a revert or the
+// links rewritten by a rename (admins are exempt from all of them):
+//
+//   - the saves are rate limited, for each client address and for each user
+//     logged in;
+//   - a save whose body links to a blocked domain, or matches a blocked
+//     pattern, is refused;
+//   - a suspicious save by an anonymous editor is held in the moderation queue
+//     until an admin approves it (see moderation.go).
+
+// A rateLimiter is a set of token buckets, one for each key: a bucket holds up
+// to a minute's worth of saves, and refills continuously at the allowed rate,
+// so a client can save in bursts but not faster than the rate in the long
+// run.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]bucket)}
+}
+
+// Past this number of buckets, the full ones are dropped: they are in the
+// same state as new ones.
+const maxBuckets = 10000
+
+// The method allow takes a token from the bucket of key, which refills at
+// perMinute tokens a minute. If the bucket is empty, it returns false and how
+// long to wait for the next token.
+func (l *rateLimiter) allow(key string, perMinute int,
+	now time.Time) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size, rate := float64(perMinute), float64(perMinute)/60
+	b := l.refill(key, size, rate, now)
+	if b.tokens < 1 {
+		l.buckets[key] = b
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	l.buckets[key] = b
+	if len(l.buckets) > maxBuckets {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= size {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	return true, 0
+}
+
+// The method wait returns how long to wait for the bucket of key, which
+// refills at perMinute tokens a minute, to hold a token again, or 0 if it
+// holds one; unlike allow, it takes nothing from the bucket.
+func (l *rateLimiter) wait(key string, perMinute int,
+	now time.Time) time.Duration {
+	if perMinute <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size, rate := float64(perMinute), float64(perMinute)/60
+	b := l.refill(key, size, rate, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// The method refill returns the bucket of key with the tokens added since it
+// was last used; l.mu must be held.
+func (l *rateLimiter) refill(key string, size, rate float64,
+	now time.Time) bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = bucket{tokens: size, last: now}
+	}
+	b.tokens = min(size, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b
+}
+
+// The function clientAddress returns the address of the client of a request,
+// without the port.
+func clientAddress(r *http.Request) string {
+	// RemoteAddr has the form "host:port"; SplitHostPort fails only if the
+	// server was not able to record it that way.
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// An editor is who saves a page: the user logged in (nil for an anonymous
+// one), and the address of their client.
+type editor struct {
+	user *User
+	addr string
+}
+
+// The method requestEditor returns the editor making a request.
+func (wk *Wiki) requestEditor(r *http.Request) editor {
+	return editor{user: wk.currentUser(r), addr: clientAddress(r)}
+}
+
+// The method author returns the name recorded in the revisions saved by the
+// editor: the name of the user, or the address of an anonymous one.
+func (e editor) author() string {
+	if e.user != nil {
+		return e.user.Name
+	}
+	return e.addr
+}
+
+func (e editor) admin() bool {
+	return e.user != nil && e.user.Admin
+}
+
+// A refusedError is returned for a save refused by the controls, with the
+// status to answer and, for the rate limits, the number of seconds to wait.
+type refusedError struct {
+	status     int
+	reason     string
+	retryAfter int
+}
+
+func (e *refusedError) Error() string {
+	return e.reason
+}
+
+// A heldError is returned for a save held for moderation instead of written.
+type heldError struct {
+	held heldSave
+}
+
+func (e *heldError) Error() string {
+	return "the save is held for moderation"
+}
+
+// The method limitSaves applies the rate limits to a save by an editor; if
+// the editor has saved too much, it returns a *refusedError.
+func (wk *Wiki) limitSaves(e editor) error {
+	if e.admin() {
+		return nil
+	}
+	now := wk.clock()
+	ok, wait := wk.ipLimiter.allow(e.addr, wk.saveRateIP, now)
+	if ok && e.user != nil {
+		ok, wait = wk.userLimiter.allow(e.user.Name, wk.saveRateUser, now)
+	}
+	if ok {
+		return nil
+	}
+	seconds := int(wait/time.Second) + 1
+	return &refusedError{status: http.StatusTooManyRequests,
+		reason: fmt.Sprintf("Too many saves: try again in %d seconds.",
+			seconds), retryAfter: seconds}
+}
+
+// A Blocklist lists the domains that pages must not link to (a domain blocks
+// its subdomains too), and the regular expressions that their bodies must not
+// match. It is read from a JSON file such as:
+//
+//	{"domains": ["spam.example"], "patterns": ["(?i)cheap pills"]}
+type Blocklist struct {
+	Domains  []string `json:"domains"`
+	Patterns []string `json:"patterns"`
+	patterns []*regexp.Regexp
+}
+
+// The function LoadBlocklist reads a blocklist from a JSON file; a missing
+// file is just an empty blocklist.
+func LoadBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, p := range b.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		b.patterns = append(b.patterns, re)
+	}
+	for i, d := range b.Domains {
+		b.Domains[i] = strings.ToLower(strings.Trim(d, "."))
+	}
+	return b, nil
+}
+
+// The method externalLinks returns the links (and images) of a body leading
+// to other sites, as the renderer writes them: text that only looks like a
+// URL is not a link.
+func (wk *Wiki) externalLinks(body []byte) []*url.URL {
+	var links []*url.URL
+	r := &markdownRenderer{wiki: wk,
+		exists:   func(title string) bool { return true },
+		external: func(u *url.URL) { links = append(links, u) }}
+	r.render(body)
+	return links
+}
+
+// The function linkHost returns the host name of an external link, in lower
+// case and without the final dot of a fully qualified name, as in
+// "spam.example.", which leads to the same site.
+func linkHost(u *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// The method check returns why a body with the given external links is
+// blocked, or "" if it is not.
+func (b *Blocklist) check(body []byte, links []*url.URL) string {
+	for _, link := range links {
+		host := linkHost(link)
+		for _, d := range b.Domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return "The page links to a blocked site: " + d + "."
+			}
+		}
+	}
+	for _, re := range b.patterns {
+		if re.Match(body) {
+			return "The page contains blocked content."
+		}
+	}
+	return ""
+}
+
+// The function newLinks returns the external links of links that are not in
+// old.
+func newLinks(old, links []*url.URL) []string {
+	seen := make(map[string]bool)
+	for _, link := range old {
+		seen[link.String()] = true
+	}
+	var added []string
+	for _, link := range links {
+		if s := link.String(); !seen[s] {
+			seen[s] = true
+			added = append(added, s)
+		}
+	}
+	return added
+}
+
+// The method moderationReasons returns why a save replacing the body old
+// should be held for moderation, or nil if it can be written immediately;
+// links are the external links of the new body.
+func (wk *Wiki) moderationReasons(e editor, old []byte, p *Page,
+	links []*url.URL) []string {
+	if e.user != nil {
+		return nil
+	}
+	switch wk.moderate {
+	case "all":
+		return []string{"anonymous edit"}
+	case "links":
+		var reasons []string
+		for _, link := range newLinks(wk.externalLinks(old), links) {
+			reasons = append(reasons, "new link to "+link)
+		}
+		return reasons
+	}
+	return nil
+}
+
+// The method screenSave applies the blocklist and the moderation rules to a
+// save replacing the body old of revision base. It returns a *refusedError if
+// the save is refused, and a *heldError once it is held for moderation.
+func (wk *Wiki) screenSave(e editor, p *Page, old []byte, base int) error {
+	if e.admin() {
+		return nil
+	}
+	links := wk.externalLinks(p.Body)
+	if reason := wk.blocked.check(p.Body, links); reason != "" {
+		return &refusedError{status: http.StatusForbidden, reason: reason}
+	}
+	reasons := wk.moderationReasons(e, old, p, links)
+	if len(reasons) == 0 {
+		return nil
+	}
+	h := heldSave{Title: p.Title, Body: string(p.Body), Base: base,
+		Revision: Revision{Author: p.Author, Comment: p.Comment,
+			Time: wk.clock()}, Reasons: reasons}
+	if err := wk.moderation.hold(&h); err != nil {
+		return err
+	}
+	return &heldError{held: h}
+}
+
+// The method saveAs is the way every save made for an editor goes: it applies
+// the rate limits, then saves the page as saveAt does, unless screenSave
+// refuses or holds it. The page is screened once the revision base is known to
+// be the latest, so a save held for moderation does not hide a conflict.
+func (wk *Wiki) saveAs(p *Page, e editor, base int) error {
+	if err := wk.limitSaves(e); err != nil {
+		return err
+	}
+	return wk.saveScreened(p, e, base)
+}
+
+// The method saveScreened is saveAs without the rate limits, for the saves
+// that are part of a larger change already counted, as the links rewritten by
+// a rename.
+func (wk *Wiki) saveScreened(p *Page, e editor, base int) error {
+	unlock := wk.lockTitle(p.Title)
+	defer unlock()
+	latest, err := wk.latestAt(p.Title, base)
+	if err != nil {
+		return err
+	}
+	if err := wk.screenSave(e, p, latest.Body, base); err != nil {
+		return err
+	}
+	return wk.commit(p)
+}
+
+// The method refuseSave answers a save refused or held by the controls (or a
+// request refused for too many failed logins, see auth.go), and returns false
+// if err is another error.
+func (wk *Wiki) refuseSave(w http.ResponseWriter, err error) bool {
+	var refused *refusedError
+	var held *heldError
+	switch {
+	case errors.As(err, &refused):
+		if refused.retryAfter > 0 {
+			// Retry-After tells the client how many seconds to wait.
+			w.Header().Set("Retry-After", fmt.Sprint(refused.retryAfter))
+		}
+		wk.httpError(w, refused.reason, refused.status)
+	case errors.As(err, &held):
+		// The status 202 Accepted tells the client that the request will be
+		// processed later.
+		wk.renderStatus(w, http.StatusAccepted, "held", held.held)
+	default:
+		return false
+	}
+	return true
+}
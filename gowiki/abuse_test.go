@@ -0,0 +1,196 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 2 {
+		if ok, _ := l.allow("a", 2, now); !ok {
+			t.Fatalf("save %d refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", 2, now)
+	if ok || wait != 30*time.Second {
+		t.Errorf("third save: %v, wait %v, want false, 30s", ok, wait)
+	}
+	if ok, _ := l.allow("b", 2, now); !ok {
+		t.Error("other key refused")
+	}
+	if ok, _ := l.allow("a", 2, now.Add(30*time.Second)); !ok {
+		t.Error("save refused after the wait")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	b := &Blocklist{Domains: []string{"spam.example"},
+		patterns: []*regexp.Regexp{regexp.MustCompile(`(?i)cheap pills`)}}
+	for body, blocked := range map[string]bool{
+		"See [this](https://www.spam.example/offer).": true,
+		"See [this](http://user@SPAM.example:8080/).": true,
+		"See [this](//spam.example/).":                true,
+		"See [this](https://spam.example./).":         true,
+		"See ![this](https://spam.example/ad.png).":   true,
+		"See [this](https://notspam.example/).":       false,
+		// Neither is a link: the first is text, the second is written "#".
+		"See https://spam.example/.":       false,
+		"See [this](https:spam.example/).": false,
+		"Buy Cheap Pills now":              true,
+		"See FrontPage.":                   false,
+	} {
+		links := wk.externalLinks([]byte(body))
+		if got := b.check([]byte(body), links) != ""; got != blocked {
+			t.Errorf("check(%q) blocked = %v, want %v", body, got, blocked)
+		}
+	}
+	got := newLinks(wk.externalLinks([]byte("[a](https://a.example/)")),
+		wk.externalLinks([]byte(
+			"[a](https://a.example/) and [b](https://b.example/x)")))
+	if want := []string{"https://b.example/x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("newLinks = %q, want %q", got, want)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome\n\nAbout.\n\nEnd.")})
+	wk := newTestWiki(t, Options{Store: s,
+		ACL: []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}},
+		Users: &UserDB{users: map[string]*User{"root": {Name: "root",
+			Admin: true}}},
+		Moderate: "links"})
+
+	form := url.Values{"base": {"1"},
+		"body": {"Welcome, see [this](https://example.com/)\n\nAbout.\n\nEnd."}}
+	if w := do(wk, "POST", "/save/FrontPage", form); w.Code !=
+		http.StatusAccepted {
+		t.Fatalf("save with a link: status %d", w.Code)
+	}
+	if p, _ := s.Load("FrontPage"); p.Number != 1 {
+		t.Fatalf("held save written: revision %d", p.Number)
+	}
+	wk.blocked = &Blocklist{Domains: []string{"example.com"}}
+	w := do(wk, "POST", "/save/FrontPage", form)
+	wk.blocked = &Blocklist{}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("save with a blocked link: status %d", w.Code)
+	}
+	held := wk.moderation.list()
+	if len(held) != 1 || held[0].Reasons[0] !=
+		"new link to https://example.com/" {
+		t.Fatalf("queue %v", held)
+	}
+
+	// Meanwhile, the page is changed by somebody else.
+	p := &Page{Title: "FrontPage", Body: []byte("Welcome\n\nAbout.\n\nThe end.")}
+	if err := wk.save(p); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := wk.sessions.create("root", wk.clock())
+	defer wk.sessions.remove(token)
+	moderate := func(action string) int {
+		form := url.Values{"id": {held[0].ID}, "action": {action},
+			"csrf": {"t0k3n"}}
+		r := httptest.NewRequest("POST", "/moderation",
+			strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		return w.Code
+	}
+	if w := do(wk, "GET", "/moderation", nil); w.Code != http.StatusFound {
+		t.Errorf("anonymous moderation: status %d", w.Code)
+	}
+	if got := moderate("approve"); got != http.StatusFound {
+		t.Fatalf("approve: status %d", got)
+	}
+	p, err := s.Load("FrontPage")
+	want := "Welcome, see [this](https://example.com/)\n\nAbout.\n\nThe end.\n"
+	if err != nil || string(p.Body) != want {
+		t.Errorf("approved page %q, %v, want %q", p.Body, err, want)
+	}
+	if len(wk.moderation.list()) != 0 {
+		t.Error("approved save still queued")
+	}
+}
+
+// The saves made through the API, by a revert and by a rename go through the
+// same controls as the edit form.
+func TestScreenedSaves(t *testing.T) {
+	s := newMemStore()
+	for _, p := range []*Page{
+		{Title: "FrontPage", Body: []byte("Hi [x](https://spam.example/)")},
+		{Title: "FrontPage", Body: []byte("Welcome")},
+		{Title: "OldPage", Body: []byte("content")},
+		{Title: "Ads", Body: []byte("See OldPage, [x](https://spam.example/)")},
+	} {
+		if err := s.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wk := newTestWiki(t, Options{Store: s,
+		ACL:       []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}},
+		Blocklist: &Blocklist{Domains: []string{"spam.example"}},
+		Moderate:  "links"})
+	put := func(title, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/pages/"+title,
+			strings.NewReader(`{"body": "`+body+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := put("Spam", "Buy at [this](https://spam.example/)"); w.Code !=
+		http.StatusForbidden {
+		t.Errorf("API save with a blocked link: status %d", w.Code)
+	}
+	if w := put("Spam", "See [this](https://example.com/)"); w.Code !=
+		http.StatusAccepted || len(wk.moderation.list()) != 1 {
+		t.Errorf("API save with a link: status %d, queue %v", w.Code,
+			wk.moderation.list())
+	}
+	if _, err := s.Load("Spam"); err == nil {
+		t.Error("refused or held API saves written")
+	}
+
+	w := do(wk, "POST", "/revert/FrontPage", url.Values{"rev": {"1"}})
+	if p, _ := s.Load("FrontPage"); w.Code != http.StatusForbidden ||
+		p.Number != 2 {
+		t.Errorf("revert to a blocked link: status %d, revision %d", w.Code,
+			p.Number)
+	}
+
+	skipped, err := wk.renamePage("OldPage", "NewPage",
+		editor{addr: "192.0.2.1"}, true, false)
+	if err != nil || !reflect.DeepEqual(skipped, []string{"Ads"}) {
+		t.Errorf("rename: skipped %q, %v, want [Ads]", skipped, err)
+	}
+	if p, _ := s.Load("Ads"); p.Number != 1 {
+		t.Errorf("page with a blocked link rewritten: revision %d", p.Number)
+	}
+
+	// The API saves count against the same rate limit as the others.
+	wk.saveRateIP = 1
+	if w := put("Other", "Hello"); w.Code != http.StatusCreated {
+		t.Errorf("API save: status %d", w.Code)
+	}
+	w = put("Another", "Hello")
+	if w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") == "" {
+		t.Errorf("API save past the rate limit: status %d, headers %v",
+			w.Code, w.Header())
+	}
+}
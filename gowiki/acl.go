@@ -0,0 +1,146 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// An AccessLevel tells what a user may do with a page; each level includes the
+// ones below it.
+type AccessLevel int
+
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessEdit
+	AccessAdmin
+)
+
+var levelNames = []string{"none", "read", "edit", "admin"}
+
+func (l AccessLevel) String() string {
+	return levelNames[l]
+}
+
+// The method UnmarshalText lets the JSON decoder read a level from its name.
+func (l *AccessLevel) UnmarshalText(text []byte) error {
+	for i, name := range levelNames {
+		if string(text) == name {
+			*l = AccessLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown access level %q", text)
+}
+
+// An ACLRule grants a level of access to the pages whose title starts with
+// Prefix ("" for all the pages). Who is the name of a user, "*" for anybody
+// (logged in or not), or "users" for any user logged in.
+type ACLRule struct {
+	Prefix string
+	Who    string
+	Level  AccessLevel
+}
+
+// The function LoadACL reads the rules from a JSON file; a missing file is just
+// an empty list of rules.
+func LoadACL(path string) ([]ACLRule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []ACLRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+func (rule ACLRule) appliesTo(u *User) bool {
+	switch rule.Who {
+	case "*":
+		return true
+	case "users":
+		return u != nil
+	}
+	return u != nil && rule.Who == u.Name
+}
+
+// The method access returns the level of access of a user (nil for an
+// anonymous one) to a page. The rules with the longest prefix matching the
+// title win: a rule for "Team/" overrides a rule for all the pages; among
+// them, the highest level is granted.
+func (wk *Wiki) access(u *User, title string) AccessLevel {
+	if u != nil && u.Admin {
+		return AccessAdmin
+	}
+	level, longest := AccessNone, -1
+	for _, rule := range wk.acl {
+		if !strings.HasPrefix(title, rule.Prefix) || !rule.appliesTo(u) {
+			continue
+		}
+		switch {
+		case len(rule.Prefix) > longest:
+			level, longest = rule.Level, len(rule.Prefix)
+		case len(rule.Prefix) == longest:
+			level = max(level, rule.Level)
+		}
+	}
+	if longest >= 0 {
+		return level
+	}
+	if u != nil {
+		return AccessEdit
+	}
+	return AccessRead
+}
+
+// The method allow checks that the user of the request has at least the
+// given level of access to the page. If not, it answers the request and
+// returns false: anonymous users are sent to the login page when they try to
+// get a page, and everybody else gets a 403 Forbidden.
+func (wk *Wiki) allow(w http.ResponseWriter, r *http.Request, title string,
+	level AccessLevel) bool {
+	u := wk.currentUser(r)
+	if wk.access(u, title) >= level {
+		return true
+	}
+	if u == nil && r.Method == http.MethodGet {
+		http.Redirect(w, r, wk.basePath+"/login?next="+
+			url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return false
+	}
+	wk.httpError(w, "You are not allowed to do this on this page.",
+		http.StatusForbidden)
+	return false
+}
+
+// The method readable returns the titles the user of the request is allowed
+// to read.
+func (wk *Wiki) readable(r *http.Request, titles []string) []string {
+	ok := wk.canRead(r)
+	var allowed []string
+	for _, title := range titles {
+		if ok(title) {
+			allowed = append(allowed, title)
+		}
+	}
+	return allowed
+}
+
+// The method canRead returns a function telling whether the user of the
+// request is allowed to read a page.
+func (wk *Wiki) canRead(r *http.Request) func(title string) bool {
+	u := wk.currentUser(r)
+	return func(title string) bool {
+		return wk.access(u, title) >= AccessRead
+	}
+}
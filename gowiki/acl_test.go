@@ -0,0 +1,43 @@
+package gowiki
+
+import "testing"
+
+func TestAccess(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore(), ACL: []ACLRule{
+		{Prefix: "", Who: "*", Level: AccessRead},
+		{Prefix: "Team", Who: "*", Level: AccessNone},
+		{Prefix: "Team", Who: "alice", Level: AccessEdit},
+		{Prefix: "TeamPublic", Who: "users", Level: AccessRead},
+	}})
+	alice, bob := &User{Name: "alice"}, &User{Name: "bob"}
+	testcases := []struct {
+		user  *User
+		title string
+		want  AccessLevel
+	}{
+		{nil, "FrontPage", AccessRead},
+		{bob, "FrontPage", AccessRead},
+		{nil, "TeamRunbook", AccessNone},
+		{bob, "TeamRunbook", AccessNone},
+		{alice, "TeamRunbook", AccessEdit},
+		{bob, "TeamPublicNotes", AccessRead},
+		{alice, "TeamPublicNotes", AccessRead},
+		{&User{Name: "root", Admin: true}, "TeamRunbook", AccessAdmin},
+	}
+	for _, tc := range testcases {
+		if got := wk.access(tc.user, tc.title); got != tc.want {
+			t.Errorf("access(%v, %q) = %v, want %v", tc.user, tc.title, got,
+				tc.want)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	u := &User{Name: "alice"}
+	if err := u.setPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !u.checkPassword("secret") || u.checkPassword("Secret") {
+		t.Fatal("checkPassword does not match the password set")
+	}
+}
@@ -0,0 +1,255 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API lets tools read and write pages without going through the HTML
+// forms. It shares the page store, the validation of the titles, the access
+// rules and the conflict detection with the HTML handlers:
+//
+//	GET    /api/pages          the titles of the pages
+//	GET    /api/pages/{title}  the page
+//	PUT    /api/pages/{title}  create the page, or save a new revision
+//	DELETE /api/pages/{title}  delete the page
+//
+// Titles may contain slashes, as in /api/pages/Team/Runbooks/Deploy.
+//
+// The ETag of a page is the number of its latest revision; PUT and DELETE
+// accept it in If-Match, and PUT on an existing page requires it, so a tool
+// cannot overwrite a revision it has not seen.
+
+// An apiPage is the JSON representation of a page. The struct tags tell the
+// json package the names of the fields in the JSON objects; omitempty leaves a
+// field out when it has its zero value.
+type apiPage struct {
+	Title    string    `json:"title"`
+	Body     string    `json:"body"`
+	Revision int       `json:"revision,omitempty"`
+	Author   string    `json:"author,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	Time     time.Time `json:"time,omitzero"`
+	// The metadata is read from the front matter of the body: it is ignored
+	// by PUT.
+	Meta Meta `json:"meta,omitempty"`
+}
+
+func newAPIPage(p *Page) apiPage {
+	return apiPage{p.Title, string(p.Body), p.Number, p.Author, p.Comment,
+		p.Time, p.Meta}
+}
+
+// The function writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// The function etag returns the entity tag of a revision; entity tags are
+// quoted strings.
+func etag(number int) string {
+	return `"` + strconv.Itoa(number) + `"`
+}
+
+// The function ifMatch parses the If-Match header: it returns the revision
+// number it names, -1 for "*" (any revision) and ok == false if the header is
+// missing.
+func ifMatch(r *http.Request) (number int, ok bool, err error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return 0, false, nil
+	}
+	if h == "*" {
+		return -1, true, nil
+	}
+	// A page written before revisions were introduced is at revision 0, and
+	// its entity tag is "0".
+	n, err := strconv.Atoi(strings.Trim(h, `"`))
+	if err != nil || n < 0 {
+		return 0, true, errors.New("invalid If-Match header")
+	}
+	return n, true, nil
+}
+
+// The method apiTitle extracts and checks the title from the path of the
+// request, and checks that the user has the given level of access to the page;
+// if anything is wrong, it writes the error and returns "".
+func (wk *Wiki) apiTitle(w http.ResponseWriter, r *http.Request,
+	level AccessLevel) string {
+	title, ok := canonicalTitle(r.PathValue("title"))
+	if !ok {
+		apiError(w, http.StatusNotFound, "invalid title")
+		return ""
+	}
+	u := wk.currentUser(r)
+	if wk.access(u, title) < level {
+		if u == nil {
+			// The WWW-Authenticate header tells the client how to log in.
+			w.Header().Set("WWW-Authenticate", `Basic realm="gowiki"`)
+			apiError(w, http.StatusUnauthorized, "authentication required")
+		} else {
+			apiError(w, http.StatusForbidden, "forbidden")
+		}
+		return ""
+	}
+	return title
+}
+
+// The method apiListHandler handles GET /api/pages.
+func (wk *Wiki) apiListHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := wk.store.List()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	titles = wk.readable(r, titles)
+	if titles == nil {
+		// A nil slice would be encoded as null rather than as an empty array.
+		titles = []string{}
+	}
+	writeJSON(w, http.StatusOK, titles)
+}
+
+// The method apiGetHandler handles GET /api/pages/{title}.
+func (wk *Wiki) apiGetHandler(w http.ResponseWriter, r *http.Request) {
+	title := wk.apiTitle(w, r, AccessRead)
+	if title == "" {
+		return
+	}
+	p, err := wk.loadPage(title)
+	if errors.Is(err, ErrNotFound) {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", etag(p.Number))
+	writeJSON(w, http.StatusOK, newAPIPage(p))
+}
+
+// The method apiPutHandler handles PUT /api/pages/{title}; the request body
+// is a JSON object with the fields "body" and, optionally, "comment".
+func (wk *Wiki) apiPutHandler(w http.ResponseWriter, r *http.Request) {
+	title := wk.apiTitle(w, r, AccessEdit)
+	if title == "" {
+		return
+	}
+	var in apiPage
+	// The same limit as for the edit form applies.
+	r.Body = http.MaxBytesReader(w, r.Body, wk.maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	base, ok, err := ifMatch(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The page as it is before the save tells whether the save creates it:
+	// the number of the revision does not, as a page written before
+	// revisions were introduced is at revision 0, as a new one.
+	latest, err := wk.loadPage(title)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case base == -1 && !exists:
+		// "*" matches whatever the latest revision is, as long as the page
+		// exists.
+		apiError(w, http.StatusPreconditionFailed, ErrNotFound.Error())
+		return
+	case base == -1:
+		base = latest.Number
+	case !ok && exists:
+		// Without If-Match, only a new page can be created.
+		w.Header().Set("ETag", etag(latest.Number))
+		apiError(w, http.StatusPreconditionRequired,
+			"the page exists: If-Match is required")
+		return
+	}
+	p := &Page{Title: title, Body: []byte(in.Body), Revision: Revision{
+		Author:  wk.requestAuthor(r),
+		Comment: in.Comment,
+	}}
+	var conflict *conflictError
+	var refused *refusedError
+	var held *heldError
+	switch err := wk.saveAs(p, wk.requestEditor(r), base); {
+	case errors.As(err, &conflict) && !ok:
+		// The page was created in the meantime.
+		w.Header().Set("ETag", etag(conflict.Latest.Number))
+		apiError(w, http.StatusPreconditionRequired,
+			"the page exists: If-Match is required")
+	case errors.As(err, &conflict):
+		w.Header().Set("ETag", etag(conflict.Latest.Number))
+		apiError(w, http.StatusPreconditionFailed, err.Error())
+	case errors.As(err, &refused):
+		if refused.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(refused.retryAfter))
+		}
+		apiError(w, refused.status, refused.reason)
+	case errors.As(err, &held):
+		// The save is written once a moderator approves it.
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"held": true, "reasons": held.held.Reasons})
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err.Error())
+	default:
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+		}
+		w.Header().Set("ETag", etag(p.Number))
+		writeJSON(w, status, newAPIPage(p))
+	}
+}
+
+// The method apiDeleteHandler handles DELETE /api/pages/{title}.
+func (wk *Wiki) apiDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	title := wk.apiTitle(w, r, AccessAdmin)
+	if title == "" {
+		return
+	}
+	base, ok, err := ifMatch(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ok {
+		base = -1
+	}
+	err = wk.deletePage(title, base)
+	var conflict *conflictError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		apiError(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &conflict):
+		w.Header().Set("ETag", etag(conflict.Latest.Number))
+		apiError(w, http.StatusPreconditionFailed, err.Error())
+	case err != nil:
+		apiError(w, http.StatusInternalServerError, err.Error())
+	default:
+		// 204 No Content: the request succeeded, and there is nothing to add.
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
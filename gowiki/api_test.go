@@ -0,0 +1,178 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	wk := newTestWiki(t, Options{Store: s})
+	alice, root := &User{Name: "alice"}, &User{Name: "root", Admin: true}
+	for _, u := range []*User{alice, root} {
+		if err := u.setPassword("secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wk.users = &UserDB{users: map[string]*User{"alice": alice, "root": root}}
+	wk.saveRateIP, wk.saveRateUser = 0, 0
+
+	// The function call sends a request as user (anonymous if ""), with the
+	// headers given as name and value pairs.
+	call := func(user, method, target, body string,
+		header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != "" {
+			r.SetBasicAuth(user, "secret")
+		}
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("", "GET", "/api/pages", "")
+	var titles []string
+	if err := json.Unmarshal(w.Body.Bytes(), &titles); err != nil ||
+		!reflect.DeepEqual(titles, []string{"FrontPage"}) {
+		t.Errorf("list: status %d, %s", w.Code, w.Body)
+	}
+	w = call("", "GET", "/api/pages/FrontPage", "")
+	var p apiPage
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil ||
+		w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` ||
+		p.Title != "FrontPage" || p.Body != "Welcome" || p.Revision != 1 {
+		t.Errorf("get: status %d, ETag %s, %s", w.Code,
+			w.Header().Get("ETag"), w.Body)
+	}
+
+	for _, tc := range []struct {
+		name, user, method, target, body string
+		header                           []string
+		status                           int
+		etag                             string
+	}{
+		{"get a missing page", "", "GET", "/api/pages/Missing", "", nil,
+			http.StatusNotFound, ""},
+		{"get an invalid title", "", "GET", "/api/pages/-bad", "", nil,
+			http.StatusNotFound, ""},
+		{"anonymous save", "", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hi"}`, []string{"If-Match", `"1"`},
+			http.StatusUnauthorized, ""},
+		{"create", "alice", "PUT", "/api/pages/NewPage",
+			`{"body": "New", "comment": "start"}`, nil,
+			http.StatusCreated, `"1"`},
+		{"save without If-Match", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hi"}`, nil, http.StatusPreconditionRequired, `"1"`},
+		{"conditional save", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hi"}`, []string{"If-Match", `"1"`}, http.StatusOK,
+			`"2"`},
+		{"stale If-Match", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hello"}`, []string{"If-Match", `"1"`},
+			http.StatusPreconditionFailed, `"2"`},
+		{"If-Match *", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hello"}`, []string{"If-Match", "*"}, http.StatusOK,
+			`"3"`},
+		{"If-Match * on a missing page", "alice", "PUT",
+			"/api/pages/Missing", `{"body": "Hello"}`,
+			[]string{"If-Match", "*"}, http.StatusPreconditionFailed, ""},
+		{"invalid If-Match", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": "Hello"}`, []string{"If-Match", "three"},
+			http.StatusBadRequest, ""},
+		{"invalid JSON", "alice", "PUT", "/api/pages/FrontPage",
+			`{"body": `, []string{"If-Match", `"3"`}, http.StatusBadRequest,
+			""},
+		{"delete by a user", "alice", "DELETE", "/api/pages/NewPage", "",
+			nil, http.StatusForbidden, ""},
+		{"stale delete", "root", "DELETE", "/api/pages/FrontPage", "",
+			[]string{"If-Match", `"2"`}, http.StatusPreconditionFailed,
+			`"3"`},
+		{"delete", "root", "DELETE", "/api/pages/FrontPage", "",
+			[]string{"If-Match", `"3"`}, http.StatusNoContent, ""},
+		{"delete a missing page", "root", "DELETE", "/api/pages/FrontPage",
+			"", nil, http.StatusNotFound, ""},
+	} {
+		w := call(tc.user, tc.method, tc.target, tc.body, tc.header...)
+		if w.Code != tc.status || w.Header().Get("ETag") != tc.etag {
+			t.Errorf("%s: status %d, ETag %s, want %d, %s: %s", tc.name,
+				w.Code, w.Header().Get("ETag"), tc.status, tc.etag, w.Body)
+		}
+		if w.Code >= 400 && !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s: error without a message: %s", tc.name, w.Body)
+		}
+	}
+	if got := call("", "PUT", "/api/pages/X", "{}").Header().Get(
+		"WWW-Authenticate"); got != `Basic realm="gowiki"` {
+		t.Errorf("WWW-Authenticate %q", got)
+	}
+	if p, err := s.Load("NewPage"); err != nil || p.Author != "alice" ||
+		p.Comment != "start" {
+		t.Errorf("created page %v, %v", p, err)
+	}
+
+	// The body is limited as in the edit form.
+	wk.maxBodySize = 10
+	if w := call("alice", "PUT", "/api/pages/NewPage",
+		`{"body": "far more than ten bytes"}`, "If-Match", `"1"`); w.Code !=
+		http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: status %d", w.Code)
+	}
+}
+
+// A page written before revisions were introduced is at revision 0: the API
+// accepts the entity tag "0" it issues for it, and does not take a save of
+// it for a creation.
+func TestAPILegacyPage(t *testing.T) {
+	s, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(s.dir, "Legacy.txt"), []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk := newTestWiki(t, Options{Store: s})
+	alice := &User{Name: "alice"}
+	if err := alice.setPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	wk.users = &UserDB{users: map[string]*User{"alice": alice}}
+	wk.saveRateIP, wk.saveRateUser = 0, 0
+
+	r := httptest.NewRequest("GET", "/api/pages/Legacy", nil)
+	w := httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"0"` {
+		t.Fatalf("get: status %d, ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+	for _, tc := range []struct {
+		name, ifMatch string
+		status        int
+		etag          string
+	}{
+		{"save without If-Match", "", http.StatusPreconditionRequired, `"0"`},
+		{"conditional save", `"0"`, http.StatusOK, `"2"`},
+	} {
+		r := httptest.NewRequest("PUT", "/api/pages/Legacy",
+			strings.NewReader(`{"body": "new"}`))
+		r.SetBasicAuth("alice", "secret")
+		if tc.ifMatch != "" {
+			r.Header.Set("If-Match", tc.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		if w.Code != tc.status || w.Header().Get("ETag") != tc.etag {
+			t.Errorf("%s: status %d, ETag %s, want %d, %s: %s", tc.name,
+				w.Code, w.Header().Get("ETag"), tc.status, tc.etag, w.Body)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package gowiki
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// An archive holds a whole wiki, to move it to another host: every revision of
+// every page, and the attached files, with a manifest listing them. It is a
+// tar file compressed with gzip, or a zip file, with these entries:
+//
+//	pages/<title>/<number>.md    the body of each revision
+//	files/<title>/<name>         the files attached to each page
+//	manifest.json                the manifest, written last
+//
+// The manifest records the revisions (author, comment and time) and the
+// attachments (content type and time), each with the path, the size and the
+// SHA-256 checksum of its entry; the import checks them all before writing
+// anything.
+type archiveManifest struct {
+	Format  string        `json:"format"`
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Pages   []archivePage `json:"pages"`
+}
+
+type archivePage struct {
+	Title       string              `json:"title"`
+	Revisions   []archiveRevision   `json:"revisions"`
+	Attachments []archiveAttachment `json:"attachments,omitempty"`
+}
+
+type archiveRevision struct {
+	Number  int          `json:"number"`
+	Author  string       `json:"author,omitempty"`
+	Comment string       `json:"comment,omitempty"`
+	Time    time.Time    `json:"time"`
+	File    archiveEntry `json:"file"`
+}
+
+type archiveAttachment struct {
+	Name        string       `json:"name"`
+	ContentType string       `json:"contentType"`
+	Time        time.Time    `json:"time"`
+	File        archiveEntry `json:"file"`
+}
+
+// An archiveEntry describes an entry of the archive.
+type archiveEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+const (
+	archiveFormat       = "gowiki-archive"
+	archiveVersion      = 1
+	archiveManifestName = "manifest.json"
+)
+
+// An archiveWriter adds entries to an archive, tar.gz or zip.
+type archiveWriter interface {
+	add(name string, data []byte, modified time.Time) (archiveEntry, error)
+	Close() error
+}
+
+// The function newArchiveWriter returns a writer of an archive in the given
+// format, "tar.gz" or "zip", to w.
+func newArchiveWriter(w io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		return &tarWriter{gz, tar.NewWriter(gz)}, nil
+	case "zip":
+		return &zipWriter{zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q", format)
+}
+
+// The function checksum returns the entry describing data under name.
+func checksum(name string, data []byte) archiveEntry {
+	sum := sha256.Sum256(data)
+	return archiveEntry{Path: name, Size: int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:])}
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarWriter) add(name string, data []byte,
+	modified time.Time) (archiveEntry, error) {
+	err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name,
+		Size: int64(len(data)), Mode: 0644, ModTime: modified})
+	if err == nil {
+		_, err = a.tw.Write(data)
+	}
+	return checksum(name, data), err
+}
+
+// The method Close ends the tar file, then the gzip stream around it.
+func (a *tarWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipWriter) add(name string, data []byte,
+	modified time.Time) (archiveEntry, error) {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name,
+		Method: zip.Deflate, Modified: modified})
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	return checksum(name, data), err
+}
+
+func (a *zipWriter) Close() error {
+	return a.zw.Close()
+}
+
+// The method exportArchive writes an archive of the wiki in the given format
+// to w.
+func (wk *Wiki) exportArchive(w io.Writer, format string) error {
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+	titles, err := wk.store.List()
+	if err != nil {
+		return err
+	}
+	m := archiveManifest{Format: archiveFormat, Version: archiveVersion,
+		Created: wk.clock()}
+	for _, title := range titles {
+		ap := archivePage{Title: title}
+		revisions, err := wk.archivedRevisions(title)
+		if err != nil {
+			return err
+		}
+		for _, p := range revisions {
+			e, err := aw.add(path.Join("pages", title,
+				strconv.Itoa(p.Number)+".md"), p.Body, p.Time)
+			if err != nil {
+				return err
+			}
+			ap.Revisions = append(ap.Revisions, archiveRevision{
+				Number: p.Number, Author: p.Author, Comment: p.Comment,
+				Time: p.Time, File: e})
+		}
+		attachments, err := wk.store.Attachments(title)
+		if err != nil {
+			return err
+		}
+		for _, a := range attachments {
+			_, data, err := wk.store.LoadAttachment(title, a.Name)
+			if err != nil {
+				return err
+			}
+			e, err := aw.add(path.Join("files", title, a.Name), data, a.Time)
+			if err != nil {
+				return err
+			}
+			ap.Attachments = append(ap.Attachments, archiveAttachment{
+				Name: a.Name, ContentType: a.ContentType, Time: a.Time, File: e})
+		}
+		m.Pages = append(m.Pages, ap)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := aw.add(archiveManifestName, data, m.Created); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// The method archivedRevisions returns the revisions of a page in the order
+// they have to be saved again, oldest first. A page written before revisions
+// were introduced has no history: its body is archived as revision 1, the
+// number its first save would get.
+func (wk *Wiki) archivedRevisions(title string) ([]*Page, error) {
+	history, err := wk.store.History(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		p, err := wk.store.Load(title)
+		if err != nil {
+			return nil, err
+		}
+		p.Number = 1
+		return []*Page{p}, nil
+	}
+	revisions := make([]*Page, len(history))
+	for i, r := range history {
+		p, err := wk.store.LoadRevision(title, r.Number)
+		if err != nil {
+			return nil, err
+		}
+		revisions[len(history)-1-i] = p
+	}
+	return revisions, nil
+}
+
+// The function walkArchive calls fn for each file of the archive at name, in
+// the order they are stored; the format is told from the first bytes.
+func walkArchive(name string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Peek returns the next bytes without consuming them.
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(zf.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := fn(h.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("%s: not a tar.gz or zip archive", name)
+}
+
+// An importTarget tells what an entry of the archive is imported as: a
+// revision of a page, or an attachment.
+type importTarget struct {
+	title      string
+	revision   *archiveRevision
+	attachment *archiveAttachment
+	file       archiveEntry
+}
+
+// The method ImportArchive adds the pages of the archive at name to the
+// store, with all their revisions and attachments. The archive is read twice:
+// first to check the entries against the manifest, then to write them, so
+// nothing is written if the archive is damaged, if one of its pages exists in
+// the store already, or if the store cannot keep its revisions as they are.
+// Should writing fail all the same, the pages written are deleted again.
+func (wk *Wiki) ImportArchive(name string) (int, error) {
+	var m *archiveManifest
+	seen := make(map[string]archiveEntry)
+	order := make(map[string]int)
+	err := walkArchive(name, func(entry string, r io.Reader) error {
+		if entry == archiveManifestName {
+			m = &archiveManifest{}
+			return json.NewDecoder(r).Decode(m)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		seen[entry] = archiveEntry{Path: entry, Size: n,
+			SHA256: hex.EncodeToString(h.Sum(nil))}
+		order[entry] = len(order)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if m == nil {
+		return 0, errors.New("the archive has no manifest")
+	}
+	if m.Format != archiveFormat || m.Version != archiveVersion {
+		return 0, fmt.Errorf("unsupported archive: format %q, version %d",
+			m.Format, m.Version)
+	}
+	targets, err := wk.checkManifest(m, seen, order)
+	if err != nil {
+		return 0, err
+	}
+
+	var written []string
+	err = walkArchive(name, func(entry string, r io.Reader) error {
+		t, ok := targets[entry]
+		if !ok {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		// The archive may have changed since it was checked.
+		if checksum(entry, data) != t.file {
+			return fmt.Errorf("%s: wrong checksum", entry)
+		}
+		if a := t.attachment; a != nil {
+			return wk.store.SaveAttachment(t.title, &Attachment{Name: a.Name,
+				ContentType: a.ContentType, Time: a.Time}, data)
+		}
+		rev := t.revision
+		p := &Page{Title: t.title, Body: data, Revision: Revision{
+			Author: rev.Author, Comment: rev.Comment, Time: rev.Time}}
+		if rev.Number == 1 {
+			written = append(written, t.title)
+		}
+		if err := wk.store.Save(p); err != nil {
+			return err
+		}
+		if p.Number != rev.Number {
+			return fmt.Errorf("%s: saved as revision %d instead of %d",
+				t.title, p.Number, rev.Number)
+		}
+		return nil
+	})
+	if err != nil {
+		for _, title := range written {
+			if derr := wk.store.Delete(title); derr != nil &&
+				!errors.Is(derr, ErrNotFound) {
+				slog.Error("import: cannot delete a page written", "title",
+					title, "err", derr)
+			}
+		}
+		return 0, err
+	}
+	return len(m.Pages), nil
+}
+
+// A store that adds no revision for a save leaving the body as it was, as the
+// git store, has the method skipsUnchanged: an archive in which a revision has
+// the body of the one before cannot be imported into it.
+type unchangedSkipper interface {
+	skipsUnchanged() bool
+}
+
+// The method checkManifest checks the manifest of an archive against the
+// entries seen in it (with their position in order), and returns what each
+// entry is imported as. The pages must not exist in the store, and their
+// revisions must be numbered from 1 and stored in that order, before their
+// attachments, and the store must be able to keep them all.
+func (wk *Wiki) checkManifest(m *archiveManifest, seen map[string]archiveEntry,
+	order map[string]int) (map[string]importTarget, error) {
+	targets := make(map[string]importTarget)
+	titles := make(map[string]bool)
+	check := func(t importTarget, last int) (int, error) {
+		e := t.file
+		got, ok := seen[e.Path]
+		switch {
+		case !ok:
+			return 0, fmt.Errorf("%s: missing from the archive", e.Path)
+		case got != e:
+			return 0, fmt.Errorf("%s: wrong size or checksum", e.Path)
+		case order[e.Path] <= last:
+			return 0, fmt.Errorf("%s: out of order", e.Path)
+		}
+		if _, ok := targets[e.Path]; ok {
+			return 0, fmt.Errorf("%s: listed twice in the manifest", e.Path)
+		}
+		targets[e.Path] = t
+		return order[e.Path], nil
+	}
+	for _, ap := range m.Pages {
+		if !validTitle(ap.Title) || titles[ap.Title] {
+			return nil, fmt.Errorf("invalid or repeated title %q", ap.Title)
+		}
+		titles[ap.Title] = true
+		if _, err := wk.store.Load(ap.Title); err == nil {
+			return nil, fmt.Errorf("%s: %w", ap.Title, ErrExists)
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if len(ap.Revisions) == 0 {
+			return nil, fmt.Errorf("%s: no revisions", ap.Title)
+		}
+		skipper, ok := wk.store.(unchangedSkipper)
+		skips := ok && skipper.skipsUnchanged()
+		last := -1
+		for i := range ap.Revisions {
+			r := &ap.Revisions[i]
+			if r.Number != i+1 {
+				return nil, fmt.Errorf("%s: revision %d out of sequence",
+					ap.Title, r.Number)
+			}
+			if skips && i > 0 &&
+				r.File.SHA256 == ap.Revisions[i-1].File.SHA256 {
+				return nil, fmt.Errorf("%s: revision %d does not change the "+
+					"page, and the store would not keep it", ap.Title, r.Number)
+			}
+			var err error
+			last, err = check(importTarget{title: ap.Title, revision: r,
+				file: r.File}, last)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i := range ap.Attachments {
+			a := &ap.Attachments[i]
+			if !validAttachmentName(a.Name) {
+				return nil, fmt.Errorf("%s: invalid attachment name %q",
+					ap.Title, a.Name)
+			}
+			if _, err := check(importTarget{title: ap.Title, attachment: a,
+				file: a.File}, last); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return targets, nil
+}
+
+// The method adminExportHandler sends an archive of the whole wiki, as
+// "tar.gz" or as "zip" according to the form value "format"; it handles the
+// URL "/admin/export", for admins only.
+func (wk *Wiki) adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wk.allow(w, r, "", AccessAdmin) {
+		return
+	}
+	format := r.FormValue("format")
+	contentType := "application/gzip"
+	switch format {
+	case "", "tar.gz":
+		format = "tar.gz"
+	case "zip":
+		contentType = "application/zip"
+	default:
+		wk.httpError(w, "Unknown archive format.", http.StatusBadRequest)
+		return
+	}
+	// Archiving a large wiki may take longer than the write timeout of the
+	// server.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil &&
+		!errors.Is(err, http.ErrNotSupported) {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": "wiki-" +
+			wk.clock().UTC().Format("20060102-150405") + "." + format}))
+	if err := wk.exportArchive(w, format); err != nil {
+		// The response has started, so the status cannot change anymore: the
+		// archive is cut short, and without its manifest the import rejects
+		// it.
+		slog.Error("export failed", "err", err)
+	}
+}
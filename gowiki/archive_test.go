@@ -0,0 +1,199 @@
+package gowiki
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The function writeArchive writes the archive of the wiki to a file in dir.
+func writeArchive(t *testing.T, wk *Wiki, dir, format string) string {
+	name := filepath.Join(dir, "wiki."+format)
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := wk.exportArchive(f, format); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestArchive(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := newMemStore()
+	for i, p := range []*Page{
+		{Title: "FrontPage", Body: []byte("Welcome")},
+		{Title: "FrontPage", Body: []byte("Welcome to [[Team/Café]]"),
+			Revision: Revision{Author: "alice", Comment: "link"}},
+		{Title: "Team/Café", Body: []byte("---\ntags: menu\n---\nCoffee")},
+	} {
+		p.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := source.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &Attachment{Name: "menu.txt", ContentType: "text/plain", Time: start}
+	err := source.SaveAttachment("Team/Café", a, []byte("espresso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := newTestWiki(t, Options{Store: source})
+	dir := t.TempDir()
+
+	for _, format := range []string{"tar.gz", "zip"} {
+		name := writeArchive(t, exported, dir, format)
+		wk := newTestWiki(t, Options{Store: newMemStore()})
+		n, err := wk.ImportArchive(name)
+		if err != nil || n != 2 {
+			t.Fatalf("%s: imported %d pages, %v", format, n, err)
+		}
+		for _, title := range []string{"FrontPage", "Team/Café"} {
+			want, _ := source.History(title)
+			got, err := wk.store.History(title)
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: history of %s %v, %v, want %v", format, title,
+					got, err, want)
+			}
+			for _, r := range want {
+				p, _ := wk.store.LoadRevision(title, r.Number)
+				q, _ := source.LoadRevision(title, r.Number)
+				if p == nil || !bytes.Equal(p.Body, q.Body) {
+					t.Errorf("%s: revision %d of %s differs", format, r.Number,
+						title)
+				}
+			}
+		}
+		got, data, err := wk.store.LoadAttachment("Team/Café", "menu.txt")
+		if err != nil || !reflect.DeepEqual(got, a) ||
+			string(data) != "espresso" {
+			t.Errorf("%s: attachment %v %q, %v", format, got, data, err)
+		}
+
+		// The pages exist now, so a second import writes nothing.
+		if _, err := wk.ImportArchive(name); !errors.Is(err, ErrExists) {
+			t.Errorf("%s: import over existing pages: %v", format, err)
+		}
+	}
+
+	// An archive whose entry does not match the manifest is refused.
+	name := writeArchive(t, exported, dir, "zip")
+	var entries []string
+	var contents [][]byte
+	walkArchive(name, func(entry string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		entries, contents = append(entries, entry), append(contents, data)
+		return err
+	})
+	f, err := os.Create(filepath.Join(dir, "bad.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aw, _ := newArchiveWriter(f, "tar.gz")
+	for i, entry := range entries {
+		if entry == "pages/FrontPage/2.md" {
+			contents[i] = []byte("Welcome to SpamSite")
+		}
+		aw.add(entry, contents[i], start)
+	}
+	aw.Close()
+	f.Close()
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	_, err = wk.ImportArchive(f.Name())
+	if err == nil || !strings.Contains(err.Error(), "pages/FrontPage/2.md") {
+		t.Errorf("damaged archive: %v", err)
+	}
+	if titles, _ := wk.store.List(); len(titles) != 0 {
+		t.Errorf("damaged archive imported %q", titles)
+	}
+}
+
+func TestArchiveStores(t *testing.T) {
+	// A page written before revisions were introduced is archived as
+	// revision 1.
+	dir := t.TempDir()
+	fs, err := newFSStore(filepath.Join(dir, "fs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fs", "OldPage.txt"),
+		[]byte("From before the history"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	name := writeArchive(t, newTestWiki(t, Options{Store: fs}), dir, "zip")
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	if n, err := wk.ImportArchive(name); err != nil || n != 1 {
+		t.Fatalf("legacy page: imported %d pages, %v", n, err)
+	}
+	if p, err := wk.store.Load("OldPage"); err != nil || p.Number != 1 ||
+		string(p.Body) != "From before the history" {
+		t.Errorf("legacy page imported as %v, %v", p, err)
+	}
+
+	// The git store keeps no revision that changes nothing, so an archive
+	// with one is refused before anything is written.
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	source := newMemStore()
+	for _, p := range []*Page{
+		{Title: "Alpha", Body: []byte("First")},
+		{Title: "Same", Body: []byte("Unchanged")},
+		{Title: "Same", Body: []byte("Unchanged"),
+			Revision: Revision{Comment: "no change"}},
+	} {
+		if err := source.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name = writeArchive(t, newTestWiki(t, Options{Store: source}), dir,
+		"tar.gz")
+	git, err := newGitStore(filepath.Join(dir, "git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk = newTestWiki(t, Options{Store: git})
+	_, err = wk.ImportArchive(name)
+	if err == nil || !strings.Contains(err.Error(), "Same: revision 2") {
+		t.Errorf("import into git: %v", err)
+	}
+	if titles, _ := wk.store.List(); len(titles) != 0 {
+		t.Errorf("refused archive imported %q", titles)
+	}
+}
+
+func TestAdminExport(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore(),
+		Users: &UserDB{users: map[string]*User{"root": {Name: "root",
+			Admin: true}}}})
+	w := do(wk, "GET", "/admin/export?format=zip", nil)
+	if w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "/login?next=%2Fadmin%2Fexport%3Fformat%3Dzip" {
+		t.Errorf("anonymous export: status %d, location %q", w.Code,
+			w.Header().Get("Location"))
+	}
+
+	token, _ := wk.sessions.create("root", wk.clock())
+	defer wk.sessions.remove(token)
+	r := httptest.NewRequest("GET", "/admin/export", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	rec := httptest.NewRecorder()
+	wk.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK ||
+		rec.Header().Get("Content-Type") != "application/gzip" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Disposition"),
+			"attachment; filename=wiki-") ||
+		!bytes.HasPrefix(rec.Body.Bytes(), []byte{0x1f, 0x8b}) {
+		t.Errorf("export: status %d, headers %v", rec.Code, rec.Header())
+	}
+}
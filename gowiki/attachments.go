@@ -0,0 +1,178 @@
+package gowiki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The name of an attachment is a single path element starting with a letter or
+// a digit, so it cannot lead outside the directory of the attachments.
+var attachmentName = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{M}\p{N}._ -]*$`)
+
+func validAttachmentName(name string) bool {
+	return attachmentName.MatchString(name)
+}
+
+// The types of the files that can be attached, as detected from their content.
+// HTML, SVG and other types that a browser would run scripts from are left
+// out, as the attachments are served from the same origin as the wiki.
+var allowedTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp",
+	"text/plain", "application/pdf", "application/zip", "application/x-gzip",
+	"application/octet-stream",
+}
+
+// The function sniffContentType detects the type of a file from its first
+// bytes, with the algorithm used by browsers (see DetectContentType).
+func sniffContentType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+func allowedType(contentType string) bool {
+	// ParseMediaType drops the parameters, such as "; charset=utf-8".
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowedTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// The function isImage tells whether an attachment, given its name, should be
+// embedded in a page as an image rather than linked.
+func isImage(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "image/")
+}
+
+// The method attachmentURL returns the URL of a file attached to a page.
+func (wk *Wiki) attachmentURL(title, name string) string {
+	return wk.pageURL("files", title+"/"+name)
+}
+
+// The method uploadHandler attaches the file sent in the multipart form
+// field "file" to a page; it handles URLs prefixed with "/upload/". The form
+// field "name", if given, replaces the name of the file.
+func (wk *Wiki) uploadHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	if !wk.requirePost(w, r) {
+		return
+	}
+	if _, err := wk.loadPage(title); err != nil {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	// MaxBytesReader stops reading the request body after the given number of
+	// bytes, so a client cannot exhaust memory or disk; some room is left for
+	// the other fields and the headers of the parts.
+	r.Body = http.MaxBytesReader(w, r.Body, wk.maxUploadSize+64<<10)
+	// ParseMultipartForm keeps in memory up to the given number of bytes of
+	// the files, and writes the rest to temporary files.
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			wk.httpError(w, fmt.Sprintf("The file is larger than %d bytes.",
+				wk.maxUploadSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		wk.httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !wk.checkCSRF(w, r) {
+		return
+	}
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		wk.httpError(w, "The form has no file.", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	name := r.FormValue("name")
+	if name == "" {
+		// Some browsers send the full path of the file on the client.
+		name = path.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	}
+	if !validAttachmentName(name) {
+		wk.httpError(w, fmt.Sprintf("Invalid file name %q.", name),
+			http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, wk.maxUploadSize+1))
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if int64(len(data)) > wk.maxUploadSize {
+		wk.httpError(w, fmt.Sprintf("The file is larger than %d bytes.",
+			wk.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// The type declared by the client is not trusted: it is detected from the
+	// content of the file.
+	a := &Attachment{Name: name, ContentType: sniffContentType(data),
+		Time: wk.clock()}
+	if !allowedType(a.ContentType) {
+		wk.httpError(w, fmt.Sprintf("Files of type %s cannot be attached.",
+			a.ContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	unlock := wk.lockTitle(title)
+	err = wk.store.SaveAttachment(title, a, data)
+	wk.rendered.invalidate(title)
+	unlock()
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, wk.pageURL("view", title), http.StatusFound)
+}
+
+// The method fileHandler serves a file attached to a page; it handles the
+// URLs "/files/<title>/<name>".
+func (wk *Wiki) fileHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("path")
+	i := strings.LastIndexByte(p, '/')
+	if i < 0 {
+		wk.httpError(w, "There is no such file.", http.StatusNotFound)
+		return
+	}
+	title, name := p[:i], p[i+1:]
+	if !validTitle(title) || !validAttachmentName(name) {
+		wk.httpError(w, "There is no such file.", http.StatusNotFound)
+		return
+	}
+	if !wk.allow(w, r, title, AccessRead) {
+		return
+	}
+	a, data, err := wk.store.LoadAttachment(title, name)
+	if errors.Is(err, ErrNotFound) {
+		wk.httpError(w, "There is no such file.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	// The browser must not guess another type, and must not run anything the
+	// file could contain.
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if !isImage(name) {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment",
+			map[string]string{"filename": name}))
+	}
+	// ServeContent handles Range requests and the If-Modified-Since header.
+	http.ServeContent(w, r, name, a.Time, bytes.NewReader(data))
+}
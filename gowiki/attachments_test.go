@@ -0,0 +1,143 @@
+package gowiki
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidAttachmentName(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"photo.png", true},
+		{"Release notes 2.txt", true},
+		{"café.pdf", true},
+		{"", false},
+		{".htaccess", false},
+		{"..", false},
+		{"../secret.txt", false},
+		{"a/b.txt", false},
+		{`a\b.txt`, false},
+		{"-rf", false},
+	} {
+		if got := validAttachmentName(test.name); got != test.valid {
+			t.Errorf("validAttachmentName(%q) = %v", test.name, got)
+		}
+	}
+}
+
+// The function upload posts a file to the upload handler, with a valid CSRF
+// token; name, if not empty, is sent in the form field "name".
+func upload(h http.Handler, title, filename, name string,
+	data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	m := multipart.NewWriter(&body)
+	m.WriteField("csrf", "t0k3n")
+	if name != "" {
+		m.WriteField("name", name)
+	}
+	f, _ := m.CreateFormFile("file", filename)
+	f.Write(data)
+	m.Close()
+	r := httptest.NewRequest("POST", "/upload/"+title, &body)
+	r.Header.Set("Content-Type", m.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpload(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	wk := newTestWiki(t, Options{Store: s})
+	wk.acl = []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}}
+	wk.maxUploadSize = 1 << 10
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	for _, test := range []struct {
+		about, title, filename, name string
+		data                         []byte
+		status                       int
+	}{
+		{"an image", "FrontPage", `C:\Users\me\photo.png`, "", png,
+			http.StatusFound},
+		{"a text file", "FrontPage", "notes.txt", "", []byte("Some notes"),
+			http.StatusFound},
+		{"a missing page", "Missing", "notes.txt", "", []byte("Notes"),
+			http.StatusNotFound},
+		{"an invalid name", "FrontPage", "notes.txt", "../notes.txt",
+			[]byte("Notes"), http.StatusBadRequest},
+		{"a hidden file", "FrontPage", ".notes", "", []byte("Notes"),
+			http.StatusBadRequest},
+		{"HTML", "FrontPage", "page.txt", "",
+			[]byte("<html><script>alert(1)</script>"),
+			http.StatusUnsupportedMediaType},
+		{"SVG", "FrontPage", "logo.png", "",
+			[]byte(`<?xml version="1.0"?>` +
+				`<svg xmlns="http://www.w3.org/2000/svg">`),
+			http.StatusUnsupportedMediaType},
+		{"a file over the limit", "FrontPage", "big.txt", "",
+			bytes.Repeat([]byte("a"), 1<<10+1),
+			http.StatusRequestEntityTooLarge},
+		{"a request over the limit", "FrontPage", "big.txt", "",
+			bytes.Repeat([]byte("a"), 1<<17), http.StatusRequestEntityTooLarge},
+	} {
+		if w := upload(wk, test.title, test.filename, test.name,
+			test.data); w.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.about, w.Code,
+				test.status, w.Body)
+		}
+	}
+	if _, _, err := s.LoadAttachment("FrontPage", "big.txt"); err == nil {
+		t.Error("the file over the limit was attached")
+	}
+
+	// A form without the CSRF token is refused.
+	var body bytes.Buffer
+	m := multipart.NewWriter(&body)
+	f, _ := m.CreateFormFile("file", "notes.txt")
+	f.Write([]byte("Notes"))
+	m.Close()
+	r := httptest.NewRequest("POST", "/upload/FrontPage", &body)
+	r.Header.Set("Content-Type", m.FormDataContentType())
+	w := httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("upload without a CSRF token: status %d", w.Code)
+	}
+
+	for _, test := range []struct {
+		target, contentType, disposition string
+	}{
+		{"/files/FrontPage/photo.png", "image/png", ""},
+		{"/files/FrontPage/notes.txt", "text/plain; charset=utf-8",
+			`attachment; filename=notes.txt`},
+	} {
+		w := do(wk, "GET", test.target, nil)
+		if w.Code != http.StatusOK ||
+			w.Header().Get("Content-Type") != test.contentType ||
+			w.Header().Get("Content-Disposition") != test.disposition {
+			t.Errorf("%s: status %d, headers %v", test.target, w.Code,
+				w.Header())
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" ||
+			!strings.Contains(w.Header().Get("Content-Security-Policy"),
+				"sandbox") {
+			t.Errorf("%s: unsafe headers %v", test.target, w.Header())
+		}
+	}
+	for _, target := range []string{
+		"/files/FrontPage/missing.txt", "/files/FrontPage/..%2Fnotes.txt",
+		"/files/FrontPage", "/files/Bad..Title/notes.txt",
+	} {
+		if w := do(wk, "GET", target, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package gowiki
+
+import (
+	"bufio"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// A User is an account of the wiki. The password is never stored: only a salt
+// and the key derived from the password and the salt by PBKDF2, which is slow
+// to compute on purpose, so a stolen list of users is hard to crack.
+type User struct {
+	Name       string
+	Admin      bool
+	Email      string `json:",omitempty"` // where notifications are mailed
+	Salt       []byte
+	Hash       []byte
+	Iterations int
+}
+
+// The number of iterations of PBKDF2 for new passwords.
+const passwordIterations = 100000
+
+// ErrBadLogin is returned when the user name or the password is wrong; the two
+// cases are not told apart, so the error does not reveal which accounts exist.
+var ErrBadLogin = errors.New("wrong user name or password")
+
+// The function hashPassword derives the key for password and salt.
+func hashPassword(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+}
+
+// The method setPassword replaces the password of the user, with a new random
+// salt.
+func (u *User) setPassword(password string) error {
+	u.Salt = make([]byte, 16)
+	// The function Read of crypto/rand fills the slice with cryptographically
+	// secure random bytes.
+	if _, err := rand.Read(u.Salt); err != nil {
+		return err
+	}
+	u.Iterations = passwordIterations
+	var err error
+	u.Hash, err = hashPassword(password, u.Salt, u.Iterations)
+	return err
+}
+
+// The method checkPassword tells whether password is the password of the user.
+func (u *User) checkPassword(password string) bool {
+	hash, err := hashPassword(password, u.Salt, u.Iterations)
+	// ConstantTimeCompare takes the same time whatever the position of the
+	// first difference, so the timing of the answer leaks nothing.
+	return err == nil && subtle.ConstantTimeCompare(hash, u.Hash) == 1
+}
+
+// A UserDB holds the accounts of the wiki, kept in a JSON file.
+type UserDB struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*User
+}
+
+// The function LoadUserDB reads the accounts from the file at path; a missing
+// file is just an empty list of accounts.
+func LoadUserDB(path string) (*UserDB, error) {
+	db := &UserDB{path: path, users: make(map[string]*User)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &db.users); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// The method put adds or replaces an account and writes the file.
+func (db *UserDB) put(u *User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.users[u.Name] = u
+	return writeJSONFile(db.path, db.users)
+}
+
+func (db *UserDB) get(name string) *User {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.users[name]
+}
+
+// The method authenticate returns the user with the given name and password.
+func (db *UserDB) authenticate(name, password string) (*User, error) {
+	u := db.get(name)
+	if u == nil || !u.checkPassword(password) {
+		return nil, ErrBadLogin
+	}
+	return u, nil
+}
+
+// A sessionDB maps the random tokens kept in the session cookies to the names
+// of the users who logged in. Sessions are kept in memory, so everybody has to
+// log in again after a restart.
+type sessionDB struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+const (
+	sessionCookie = "session"
+	sessionMaxAge = 7 * 24 * time.Hour
+)
+
+// The method create opens a session for the user and returns its token.
+func (db *sessionDB) create(user string, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.sessions[token] = session{user, now.Add(sessionMaxAge)}
+	return token, nil
+}
+
+// The method lookup returns the user of an open session, or "".
+func (db *sessionDB) lookup(token string, now time.Time) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s, ok := db.sessions[token]
+	if !ok {
+		return ""
+	}
+	if now.After(s.expires) {
+		delete(db.sessions, token)
+		return ""
+	}
+	return s.user
+}
+
+func (db *sessionDB) remove(token string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.sessions, token)
+}
+
+// Past this number of failed logins in a minute, a client address has to
+// wait before trying again, so the passwords cannot be guessed as fast as the
+// server can check them. A bucket holds that many failures, and refills at
+// the same rate (see rateLimiter).
+const loginFailures = 5
+
+// The method login checks the credentials sent with the request, from the
+// login form or by HTTP Basic authentication. It returns ErrBadLogin if they
+// are wrong, and a *refusedError if the client address failed too often
+// lately, without checking them.
+func (wk *Wiki) login(r *http.Request, name, password string) (*User, error) {
+	addr, now := clientAddress(r), wk.clock()
+	if wait := wk.loginLimiter.wait(addr, loginFailures, now); wait > 0 {
+		seconds := int(wait/time.Second) + 1
+		return nil, &refusedError{status: http.StatusTooManyRequests,
+			reason: fmt.Sprintf("Too many failed logins: try again in %d "+
+				"seconds.", seconds), retryAfter: seconds}
+	}
+	u, err := wk.users.authenticate(name, password)
+	if err != nil {
+		// Each failure takes a token from the bucket of the address.
+		wk.loginLimiter.allow(addr, loginFailures, now)
+	}
+	return u, err
+}
+
+// A userKey is the key of the user of a request in its context.
+type userKey struct{}
+
+// The method withUser returns the request with its user in its context:
+// checking a password is slow on purpose, so the credentials are checked once
+// for each request (see ServeHTTP), however many times the handlers ask for
+// the user. It returns a *refusedError if the client address failed to log in
+// too often lately.
+func (wk *Wiki) withUser(r *http.Request) (*http.Request, error) {
+	u, err := wk.requestUser(r)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, u)), nil
+}
+
+// The method currentUser returns the user logged in with the request, or nil
+// for an anonymous request.
+func (wk *Wiki) currentUser(r *http.Request) *User {
+	// The type assertion succeeds for a nil *User too: the request is then
+	// known to be anonymous.
+	if u, ok := r.Context().Value(userKey{}).(*User); ok {
+		return u
+	}
+	u, _ := wk.requestUser(r)
+	return u
+}
+
+// The method requestUser checks the credentials of the request. Besides the
+// session cookie, it accepts HTTP Basic authentication, which is handier for
+// tools using the API; a request with wrong credentials is anonymous.
+func (wk *Wiki) requestUser(r *http.Request) (*User, error) {
+	if name, password, ok := r.BasicAuth(); ok {
+		u, err := wk.login(r, name, password)
+		if errors.Is(err, ErrBadLogin) {
+			return nil, nil
+		}
+		return u, err
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	name := wk.sessions.lookup(c.Value, wk.clock())
+	if name == "" {
+		return nil, nil
+	}
+	return wk.users.get(name), nil
+}
+
+// The method secureCookies tells whether the cookies set in answer to the
+// request must only be sent back over HTTPS: when the request came over TLS,
+// or when the base URL of the wiki is an https one, as behind a proxy
+// handling TLS.
+func (wk *Wiki) secureCookies(r *http.Request) bool {
+	return r.TLS != nil ||
+		strings.HasPrefix(strings.ToLower(wk.baseURL), "https://")
+}
+
+// The function safeNext returns the local path to go back to after logging in,
+// ignoring anything that would lead to another site. The path is relative to
+// the prefix the wiki is mounted under.
+// Browsers read a backslash as a slash, so "/\evil.example" leads to another
+// site just as "//evil.example" does, and they drop some control characters,
+// so "/\t/evil.example" does too: paths with either are refused.
+func safeNext(next string) string {
+	if len(next) == 0 || next[0] != '/' || strings.HasPrefix(next, "//") ||
+		strings.ContainsFunc(next, func(r rune) bool {
+			return r == '\\' || unicode.IsControl(r)
+		}) {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return next
+}
+
+// The method loginHandler shows the login form and checks the credentials
+// submitted with it; it handles the URL "/login".
+func (wk *Wiki) loginHandler(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	data := struct{ Next, Error, CSRF string }{next, "", wk.csrfToken(w, r)}
+	if r.Method != http.MethodPost {
+		wk.renderTemplate(w, "login", data)
+		return
+	}
+	if !wk.checkCSRF(w, r) {
+		return
+	}
+	u, err := wk.login(r, r.FormValue("name"), r.FormValue("password"))
+	if err != nil {
+		data.Error = err.Error()
+		status := http.StatusUnauthorized
+		var refused *refusedError
+		if errors.As(err, &refused) {
+			status = refused.status
+			w.Header().Set("Retry-After", fmt.Sprint(refused.retryAfter))
+		}
+		wk.renderStatus(w, status, "login", data)
+		return
+	}
+	token, err := wk.sessions.create(u.Name, wk.clock())
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// HttpOnly hides the cookie from scripts, SameSite keeps browsers from
+	// sending it with requests started by other sites, and Secure from
+	// sending it over plain HTTP.
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     wk.basePath + "/",
+		MaxAge:   int(sessionMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   wk.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, wk.basePath+next, http.StatusFound)
+}
+
+// The method logoutHandler closes the session; it handles the URL "/logout".
+func (wk *Wiki) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !wk.requirePost(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		wk.sessions.remove(c.Value)
+	}
+	// A negative MaxAge tells the browser to delete the cookie.
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: wk.basePath + "/",
+		MaxAge: -1, Secure: wk.secureCookies(r)})
+	http.Redirect(w, r, wk.basePath+"/login", http.StatusFound)
+}
+
+var validUserName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// The method AddUser creates or updates an account, reading its password
+// from the first line of in. email may be empty.
+func (db *UserDB) AddUser(name, email string, admin bool, in io.Reader) error {
+	if !validUserName.MatchString(name) {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	if email != "" {
+		// ParseAddress also accepts a display name, such as
+		// "Alice <alice@example.com>"; only the address is kept.
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return fmt.Errorf("invalid mail address %q", email)
+		}
+		email = addr.Address
+	}
+	password, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("empty password")
+	}
+	u := &User{Name: name, Admin: admin, Email: email}
+	if err := u.setPassword(password); err != nil {
+		return err
+	}
+	return db.put(u)
+}
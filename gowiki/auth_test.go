@@ -0,0 +1,200 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeNext(t *testing.T) {
+	testcases := []struct {
+		in, want string
+	}{
+		{"/view/FrontPage", "/view/FrontPage"},
+		{"/view/FrontPage?rev=2", "/view/FrontPage?rev=2"},
+		{"", "/"},
+		{"view/FrontPage", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/view\\..\\x", "/"},
+		{"/\t/evil.example", "/"},
+		{"/\x00", "/"},
+		{"https://evil.example/", "/"},
+	}
+	for _, tc := range testcases {
+		if got := safeNext(tc.in); got != tc.want {
+			t.Errorf("safeNext(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	wk := newTestWiki(t, Options{Store: s, Prefix: "/wiki",
+		Clock: func() time.Time { return now }})
+	alice := &User{Name: "alice"}
+	if err := alice.setPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	wk.users = &UserDB{users: map[string]*User{"alice": alice}}
+
+	login := func(password, next string) *httptest.ResponseRecorder {
+		return do(wk, "POST", "/wiki/login", url.Values{"name": {"alice"},
+			"password": {password}, "next": {next}})
+	}
+	if w := login("wrong", ""); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Body.String(), ErrBadLogin.Error()) {
+		t.Errorf("wrong password: status %d", w.Code)
+	}
+	if w := login("secret", "/\\evil.example"); w.Header().Get("Location") !=
+		"/wiki/" {
+		t.Errorf("login to another site: location %q",
+			w.Header().Get("Location"))
+	}
+	w := login("secret", "/view/FrontPage")
+	if w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "/wiki/view/FrontPage" {
+		t.Fatalf("login: status %d, location %q", w.Code,
+			w.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if session == nil || session.Path != "/wiki/" || !session.HttpOnly {
+		t.Fatalf("session cookie %v", session)
+	}
+
+	// The function loggedIn tells whether the session is open.
+	loggedIn := func() bool {
+		r := httptest.NewRequest("GET", "/wiki/view/FrontPage", nil)
+		r.AddCookie(session)
+		u := wk.currentUser(r)
+		return u != nil && u.Name == "alice"
+	}
+	if !loggedIn() {
+		t.Fatal("not logged in with the session cookie")
+	}
+	now = now.Add(sessionMaxAge + time.Second)
+	if loggedIn() {
+		t.Error("session still open after it expired")
+	}
+
+	w = login("secret", "")
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if !loggedIn() {
+		t.Fatal("not logged in again")
+	}
+	form := url.Values{"csrf": {"t0k3n"}}
+	r := httptest.NewRequest("POST", "/wiki/logout",
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	if w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "/wiki/login" {
+		t.Errorf("logout: status %d, location %q", w.Code,
+			w.Header().Get("Location"))
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("logout cookies %v", c)
+	}
+	if loggedIn() {
+		t.Error("session still open after logging out")
+	}
+}
+
+// The credentials of a request are checked once, and the failed logins of a
+// client address are limited.
+func TestLoginFailures(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	wk := newTestWiki(t, Options{Store: s,
+		Clock: func() time.Time { return now }})
+	alice := &User{Name: "alice"}
+	if err := alice.setPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	wk.users = &UserDB{users: map[string]*User{"alice": alice}}
+	get := func(addr, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/view/FrontPage", nil)
+		r.RemoteAddr = addr + ":1234"
+		r.SetBasicAuth("alice", password)
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		return w
+	}
+
+	// Each request with a wrong password counts as one failure, however
+	// many times its handler asks for the user.
+	for i := range loginFailures {
+		if w := get("192.0.2.1", "wrong"); w.Code != http.StatusOK {
+			t.Fatalf("failed login %d: status %d", i+1, w.Code)
+		}
+	}
+	w := get("192.0.2.1", "secret")
+	if w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") == "" {
+		t.Errorf("login past the limit: status %d, Retry-After %q", w.Code,
+			w.Header().Get("Retry-After"))
+	}
+	form := do(wk, "POST", "/login",
+		url.Values{"name": {"alice"}, "password": {"secret"}})
+	if form.Code != http.StatusTooManyRequests {
+		t.Errorf("login form past the limit: status %d", form.Code)
+	}
+	if w := get("192.0.2.2", "secret"); w.Code != http.StatusOK {
+		t.Errorf("login from another address: status %d", w.Code)
+	}
+	now = now.Add(12 * time.Second)
+	if w := get("192.0.2.1", "secret"); w.Code != http.StatusOK {
+		t.Errorf("login after the wait: status %d", w.Code)
+	}
+}
+
+// The cookies of a wiki served over HTTPS are only sent back over HTTPS.
+func TestSecureCookies(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	alice := &User{Name: "alice"}
+	if err := alice.setPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	wk.users = &UserDB{users: map[string]*User{"alice": alice}}
+	for _, target := range []string{"http://wiki.example/login",
+		"https://wiki.example/login"} {
+		form := url.Values{"name": {"alice"}, "password": {"secret"},
+			"csrf": {"t0k3n"}}
+		r := httptest.NewRequest("POST", target,
+			strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != sessionCookie ||
+			cookies[0].Secure != (r.TLS != nil) {
+			t.Errorf("%s: cookies %v", target, cookies)
+		}
+		w = httptest.NewRecorder()
+		wk.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		cookies = w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != csrfCookie ||
+			cookies[0].Secure != (r.TLS != nil) {
+			t.Errorf("%s: CSRF cookies %v", target, cookies)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package gowiki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A renderCache keeps the latest revision of the pages that have been viewed,
+// with their body rendered to HTML, so viewing a page again neither reads it
+// from the store nor renders it. The rendering of a page depends on which
+// other pages exist (the links to missing pages look different), so a save
+// creating a page, a delete or a rename empties the whole cache; any other save
+// forgets the page saved only.
+// Each change also increments the version of the cache; emptying it records
+// the time, which goes into the validators of every view response, as it
+// changes every page (see viewValidators).
+type renderCache struct {
+	mu       sync.Mutex
+	pages    map[string]renderedPage
+	version  uint64
+	modified time.Time
+	clock    func() time.Time
+}
+
+// A renderedPage is a page with its rendered body. The page is shared by the
+// requests that find it in the cache, so it must not be modified.
+type renderedPage struct {
+	page *Page
+	html template.HTML
+}
+
+func newRenderCache(clock func() time.Time) *renderCache {
+	return &renderCache{
+		pages:    make(map[string]renderedPage),
+		modified: clock(),
+		clock:    clock,
+	}
+}
+
+// The method state returns the version of the cache and the time it was last
+// emptied.
+func (c *renderCache) state() (uint64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version, c.modified
+}
+
+func (c *renderCache) get(title string) (renderedPage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rp, ok := c.pages[title]
+	return rp, ok
+}
+
+// The method put adds a page rendered when the cache had the given version; if
+// the version changed meanwhile, the page may be stale and is not added.
+func (c *renderCache) put(title string, version uint64, rp renderedPage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version == version {
+		c.pages[title] = rp
+	}
+}
+
+// The method invalidate forgets the given pages, or all of them if none is
+// given.
+func (c *renderCache) invalidate(titles ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(titles) == 0 {
+		clear(c.pages)
+		c.modified = c.clock()
+	}
+	for _, title := range titles {
+		delete(c.pages, title)
+	}
+	c.version++
+}
+
+// The method viewValidators returns the entity tag of a view response, and
+// the time it was last modified. The page shown depends on its revision, its
+// attachments, the pages linking to it, whether the reader watches it, the
+// query of the URL (the revision, the redirect stub the reader came from),
+// and on the reader: the user logged in and the CSRF token of the forms. It
+// also depends on which other pages exist, as the links to missing pages look
+// different: the time the render cache was last emptied covers that, and makes
+// the tags of a restarted wiki differ from those of the previous run.
+// So a save changes the validators of the page saved only, unless it creates
+// a page.
+func (wk *Wiki) viewValidators(r *http.Request, p *Page,
+	attachments []Attachment, watching bool, csrf string) (string, time.Time) {
+	_, modified := wk.rendered.state()
+	user := ""
+	if u := wk.currentUser(r); u != nil {
+		user = u.Name
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%d\x00%d\x00", modified.UnixNano(), p.Title,
+		p.Number, p.Time.UnixNano())
+	for _, a := range attachments {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", a.Name, a.Size,
+			a.Time.UnixNano())
+		if a.Time.After(modified) {
+			modified = a.Time
+		}
+	}
+	for _, title := range wk.links.backlinks(p.Title) {
+		fmt.Fprintf(h, "%s\x00", title)
+	}
+	fmt.Fprintf(h, "%t\x00%s\x00%s\x00%s", watching, r.URL.RawQuery, user,
+		csrf)
+	if p.Time.After(modified) {
+		modified = p.Time
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`, modified
+}
+
+// The method notModified sets the validators of a response, its entity tag
+// and the time it was last modified, and answers 304 Not Modified, without a
+// body, if the request is conditional and the client has the response already.
+// As RFC 9110 says, If-None-Match is used when present, and If-Modified-Since
+// otherwise.
+// The tag varies with the reader, the time does not: so the time is only given
+// to, and If-Modified-Since only honoured for, the anonymous readers whose
+// request carries the CSRF token of the response (fresh is true when it was
+// just issued). A copy kept by a reader who has logged in since, or whose token
+// has changed, is then always sent again.
+func (wk *Wiki) notModified(w http.ResponseWriter, r *http.Request, etag string,
+	modified time.Time, fresh bool) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	byTime := !fresh && wk.currentUser(r) == nil
+	if byTime {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	// Caches must check with the wiki before reusing a response, and keep one
+	// for each set of cookies, which carry the user and the CSRF token.
+	h.Set("Cache-Control", "no-cache")
+	h.Add("Vary", "Cookie")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else {
+		// The time of an HTTP header has a resolution of one second.
+		t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if !byTime || err != nil || modified.Truncate(time.Second).After(t) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// The function etagMatch tells whether an If-None-Match header, a list of
+// entity tags or "*", matches etag. The comparison is weak: a tag matches its
+// weak form "W/...".
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestViewCache(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	s.Save(&Page{Title: "Other", Body: []byte("Elsewhere")})
+	wk := newTestWiki(t, Options{Store: s})
+	get := func(token string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/view/FrontPage", nil)
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("t0k3n")
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("status %d, ETag %q, Last-Modified %q", w.Code, etag,
+			modified)
+	}
+	if w := get("t0k3n", "If-None-Match", etag); w.Code !=
+		http.StatusNotModified || w.Body.Len() > 0 {
+		t.Errorf("If-None-Match: status %d", w.Code)
+	}
+	if w := get("t0k3n", "If-Modified-Since", modified); w.Code !=
+		http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d", w.Code)
+	}
+	// The forms of another reader carry another token, and a reader without
+	// a token gets a new one.
+	if w := get("other", "If-None-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("other reader: status %d", w.Code)
+	}
+	if w := get("", "If-Modified-Since", modified); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since with a new token: status %d", w.Code)
+	}
+	// The page is served from the cache to a reader without any cookie too.
+	w = httptest.NewRecorder()
+	wk.ServeHTTP(w, httptest.NewRequest("GET", "/view/FrontPage", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("reader without cookies: status %d", w.Code)
+	}
+	// A user logged in gets no time, and the copies kept by the anonymous
+	// reader are sent again.
+	wk.users = &UserDB{users: map[string]*User{"alice": {Name: "alice"}}}
+	token, _ := wk.sessions.create("alice", wk.clock())
+	defer wk.sessions.remove(token)
+	r := httptest.NewRequest("GET", "/view/FrontPage", nil)
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	r.Header.Set("If-Modified-Since", modified)
+	w = httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != "" {
+		t.Errorf("If-Modified-Since of a user: status %d, Last-Modified %q",
+			w.Code, w.Header().Get("Last-Modified"))
+	}
+
+	// Saving another page leaves the validators of this one alone, adding an
+	// attachment changes them.
+	other := &Page{Title: "Other", Body: []byte("Still elsewhere")}
+	if err := wk.save(other); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("t0k3n", "If-None-Match", etag); w.Code !=
+		http.StatusNotModified {
+		t.Errorf("after saving another page: status %d", w.Code)
+	}
+	s.SaveAttachment("FrontPage", &Attachment{Name: "a.txt",
+		ContentType: "text/plain"}, []byte("a"))
+	w = get("t0k3n", "If-None-Match", etag)
+	if w.Code != http.StatusOK {
+		t.Errorf("after adding an attachment: status %d", w.Code)
+	}
+	etag = w.Header().Get("ETag")
+
+	// The page is served from the cache, until it is saved by the wiki.
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Behind the cache")})
+	if w := get("t0k3n"); !strings.Contains(w.Body.String(), "Welcome") {
+		t.Errorf("page not cached: %s", w.Body.String())
+	}
+	p := &Page{Title: "FrontPage", Body: []byte("Hello again")}
+	if err := wk.save(p); err != nil {
+		t.Fatal(err)
+	}
+	w = get("t0k3n", "If-None-Match", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag ||
+		!strings.Contains(w.Body.String(), "Hello again") {
+		t.Errorf("after save: status %d, ETag %q, body %s", w.Code,
+			w.Header().Get("ETag"), w.Body.String())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Every command line flag can also be given by an environment variable, named
+// after the flag with the prefix GOWIKI_ (GOWIKI_READ_TIMEOUT for
+// -read-timeout), or by the configuration file named by -config. The command
+// line takes precedence over the environment, which takes precedence over the
+// file.
+const envPrefix = "GOWIKI_"
+
+// The function envName returns the name of the environment variable of a flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// The function configure completes the flags of fs that were not given on
+// the command line, from the environment (looked up with lookupEnv) and from
+// the configuration file. The file is a JSON object mapping the names of the
+// flags to their values, for example
+//
+//	{"addr": ":8443", "tls-cert": "cert.pem", "read-timeout": "10s"}
+func configure(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	// Visit calls the function for each flag given on the command line only,
+	// VisitAll for each defined flag.
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if v, ok := lookupEnv(envName(f.Name)); ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), err)
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	path := ""
+	if f := fs.Lookup("config"); f != nil {
+		path = f.Value.String()
+	}
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// UseNumber keeps the numbers as they were written, instead of turning
+	// them into float64 values that may be printed in exponential notation.
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var values map[string]any
+	if err := d.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, v := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
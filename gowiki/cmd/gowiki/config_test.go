@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gowiki.json")
+	os.WriteFile(file, []byte(`{"addr": ":9000", "data": "/srv/wiki",
+		"read-timeout": "5s", "max-upload": 20971520}`), 0644)
+	fs := flag.NewFlagSet("gowiki", flag.ContinueOnError)
+	fs.String("config", "", "")
+	addr := fs.String("addr", ":8080", "")
+	data := fs.String("data", "data", "")
+	timeout := fs.Duration("read-timeout", time.Minute, "")
+	upload := fs.Int64("max-upload", 0, "")
+	if err := fs.Parse([]string{"-config", file, "-addr", ":7000"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"GOWIKI_DATA": "/var/wiki", "GOWIKI_ADDR": ":6000"}
+	err := configure(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command line wins over the environment, which wins over the file.
+	if *addr != ":7000" || *data != "/var/wiki" || *timeout != 5*time.Second ||
+		*upload != 20<<20 {
+		t.Errorf("got addr %q, data %q, read-timeout %v, max-upload %d", *addr,
+			*data, *timeout, *upload)
+	}
+
+	os.WriteFile(file, []byte(`{"listen": ":9000"}`), 0644)
+	if err := configure(fs, func(string) (string, bool) { return "", false }); err == nil {
+		t.Error("unknown setting accepted")
+	}
+}
@@ -0,0 +1,238 @@
+// The command gowiki serves a wiki over HTTP, or exports and imports its
+// pages. The wiki itself is the package example.com/gowiki; the command reads
+// the settings from the flags, the environment and the configuration file
+// (see config.go), loads the files of the wiki, and runs the server.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/gowiki"
+)
+
+func main() {
+	// The work is done by run, and the program exits only once run has
+	// returned: os.Exit, which log.Fatal calls, would skip the functions
+	// deferred by run, and with them the end of the writes in progress.
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// The function run parses the command line, and serves the wiki or runs the
+// command given until it is done or fails.
+func run() (err error) {
+	// The flags that are settings of the wiki are stored directly in its
+	// Options.
+	o := gowiki.Options{}
+	// The flag package parses the command line; each call defines a flag and
+	// returns a pointer to the variable that will hold its value.
+	storeKind := flag.String("store", "fs",
+		"page store backend: fs, mem, sqlite (in a gowiki built with "+
+			"-tags sqlite) or git")
+	dataDir := flag.String("data", "data",
+		"directory where the fs and sqlite stores keep the pages")
+	gitRepo := flag.String("git-repo", "",
+		"repository of the git store, imported if it exists "+
+			"(default <data>/pages)")
+	usersFile := flag.String("users", "",
+		"file of the user accounts (default <data>/users.json)")
+	aclFile := flag.String("acl", "",
+		"file of the access control rules (default <data>/acl.json)")
+	userAdd := flag.String("useradd", "",
+		"add (or update) the named user, reading the password from stdin, "+
+			"and exit")
+	admin := flag.Bool("admin", false, "with -useradd, make the user an admin")
+	email := flag.String("email", "",
+		"with -useradd, the address the notifications are mailed to")
+	// Int64Var stores the value of the flag directly in the given variable.
+	flag.Int64Var(&o.MaxUploadSize, "max-upload", 10<<20,
+		"largest file that can be attached to a page, in bytes")
+	flag.Int64Var(&o.MaxBodySize, "max-body", 1<<20,
+		"largest page that can be saved from the edit form, in bytes")
+	templateDir := flag.String("templates", "",
+		"directory whose templates/ and themes/ override the built-in ones")
+	dev := flag.Bool("dev", false,
+		"reload the templates when the files in -templates change")
+	flag.StringVar(&o.Theme, "theme", "default",
+		"theme used when the reader has not chosen one")
+	flag.String("config", "", "JSON file of settings, named after the flags")
+	addr := flag.String("addr", ":8080", "address the server listens on")
+	tlsCert := flag.String("tls-cert", "",
+		"certificate file; with -tls-key, the server uses HTTPS")
+	tlsKey := flag.String("tls-key", "", "private key file of -tls-cert")
+	// DurationVar accepts values such as "30s" or "1m30s".
+	readTimeout := flag.Duration("read-timeout", 30*time.Second,
+		"longest time to read a request, body included")
+	writeTimeout := flag.Duration("write-timeout", time.Minute,
+		"longest time to write a response")
+	grace := flag.Duration("shutdown-timeout", 30*time.Second,
+		"longest time to wait for the requests in progress when stopping")
+	logFormat := flag.String("log-format", "text",
+		"format of the log: text or json")
+	flag.StringVar(&o.BaseURL, "base-url", "",
+		"absolute URL of the wiki in the feeds (default taken from requests)")
+	flag.IntVar(&o.SaveRateIP, "save-rate-ip", 10,
+		"saves allowed each minute from an address (0 for no limit)")
+	flag.IntVar(&o.SaveRateUser, "save-rate-user", 30,
+		"saves allowed each minute by a user logged in (0 for no limit)")
+	blocklistFile := flag.String("blocklist", "",
+		"file of the blocked link domains and patterns "+
+			"(default <data>/blocklist.json)")
+	flag.StringVar(&o.Moderate, "moderate", "links",
+		"anonymous saves held for moderation: off, links or all")
+	smtpAddr := flag.String("smtp-addr", "",
+		"SMTP server (host:port) mailing the notifications to the watchers")
+	smtpFrom := flag.String("smtp-from", "",
+		"sender address of the notifications")
+	smtpUser := flag.String("smtp-user", "",
+		"user name for the SMTP server, if it requires authentication")
+	smtpPassword := flag.String("smtp-password", "",
+		"password of -smtp-user (better given by GOWIKI_SMTP_PASSWORD)")
+	webhook := flag.String("webhook", "",
+		"URL the notifications are posted to as JSON")
+	flag.Parse()
+	if err := configure(flag.CommandLine, os.LookupEnv); err != nil {
+		return err
+	}
+	logger, err := newLogger(os.Stderr, *logFormat)
+	if err != nil {
+		return err
+	}
+	// SetDefault also sends the output of the log package to the logger.
+	slog.SetDefault(logger)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key go together")
+	}
+
+	if *usersFile == "" {
+		*usersFile = filepath.Join(*dataDir, "users.json")
+	}
+	if *aclFile == "" {
+		*aclFile = filepath.Join(*dataDir, "acl.json")
+	}
+	if *blocklistFile == "" {
+		*blocklistFile = filepath.Join(*dataDir, "blocklist.json")
+	}
+	if *dev && *templateDir == "" {
+		return errors.New("-dev needs -templates")
+	}
+	if *smtpAddr != "" && *smtpFrom == "" {
+		return errors.New("-smtp-addr needs -smtp-from")
+	}
+	o.Users, err = gowiki.LoadUserDB(*usersFile)
+	if err != nil {
+		return err
+	}
+	if *userAdd != "" {
+		return o.Users.AddUser(*userAdd, *email, *admin, os.Stdin)
+	}
+	o.ACL, err = gowiki.LoadACL(*aclFile)
+	if err != nil {
+		return err
+	}
+	o.Blocklist, err = gowiki.LoadBlocklist(*blocklistFile)
+	if err != nil {
+		return err
+	}
+	o.Moderation, err = gowiki.LoadModerationQueue(filepath.Join(*dataDir,
+		"moderation.json"))
+	if err != nil {
+		return err
+	}
+	o.Watches, err = gowiki.LoadWatchDB(filepath.Join(*dataDir, "watch.json"))
+	if err != nil {
+		return err
+	}
+	o.Store, err = gowiki.OpenStore(*storeKind, *dataDir, *gitRepo)
+	if err != nil {
+		return err
+	}
+	// The store is closed if it has anything to close (see the io.Closer
+	// interface), once the wiki is done with it; an error closing it is
+	// returned, unless run is already returning another one.
+	defer func() {
+		if c, ok := o.Store.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}()
+	if *templateDir != "" {
+		o.Templates = os.DirFS(*templateDir)
+	}
+	if *smtpAddr != "" {
+		var auth smtp.Auth
+		if *smtpUser != "" {
+			host, _, _ := strings.Cut(*smtpAddr, ":")
+			auth = smtp.PlainAuth("", *smtpUser, *smtpPassword, host)
+		}
+		o.Notifiers = append(o.Notifiers,
+			gowiki.NewSMTPNotifier(*smtpAddr, *smtpFrom, auth))
+	}
+	if *webhook != "" {
+		o.Notifiers = append(o.Notifiers, gowiki.NewWebhookNotifier(*webhook))
+	}
+	wiki, err := gowiki.New(o)
+	if err != nil {
+		return err
+	}
+	// The writes in progress are completed, and the notifications of the last
+	// saves delivered, before exiting.
+	defer wiki.Close()
+	if *dev {
+		// The go statement runs the function in a new goroutine, concurrently
+		// with the rest of the program.
+		go wiki.WatchTemplates(time.Second)
+	}
+	// The arguments left after the flags name a command to run instead of the
+	// server.
+	switch flag.Arg(0) {
+	case "":
+	case "export":
+		if flag.NArg() != 2 {
+			return errors.New("usage: gowiki [flags] export <directory>")
+		}
+		return wiki.ExportSite(flag.Arg(1))
+	case "import":
+		if flag.NArg() != 2 {
+			return errors.New("usage: gowiki [flags] import <archive>")
+		}
+		n, err := wiki.ImportArchive(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		slog.Info("imported", "archive", flag.Arg(1), "pages", n)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", flag.Arg(0))
+	}
+
+	// A Server with explicit timeouts does not let slow or stalled clients
+	// hold connections forever. The handler of the wiki is wrapped to log
+	// every request.
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           accessLog(logger, wiki),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+	// The event streams never end by themselves: they are ended when the
+	// server shuts down.
+	srv.RegisterOnShutdown(wiki.EndStreams)
+	slog.Info("listening", "addr", *addr, "tls", *tlsCert != "")
+	return serve(srv, *tlsCert, *tlsKey, *grace)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// An accessRecorder wraps the ResponseWriter of a request to remember the
+// status and the size of the response, which the ResponseWriter interface
+// does not expose.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// The method Unwrap gives http.ResponseController access to the wrapped
+// ResponseWriter, so handlers can still flush the response or change its
+// deadlines.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// The function accessLog wraps a handler so that every request is logged,
+// when it is done, as a structured record: with the JSON format, each record
+// is a JSON object that log processors can parse without guessing.
+func accessLog(logger *slog.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("uri", r.RequestURI),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+			slog.String("agent", r.UserAgent()))
+	})
+}
+
+// The function newLogger returns a logger writing to w in the given format,
+// "text" (key=value pairs) or "json".
+func newLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	}
+	return nil, errors.New("unknown log format " + format)
+}
+
+// The function serve runs the server until it fails, or until the program
+// receives SIGINT or SIGTERM (sent by service managers to stop it). Then it
+// stops accepting connections, and waits up to grace for the requests in
+// progress to finish; run then waits for the writes to the store (see the
+// method Close of the wiki), even if serve failed. The server uses TLS if certFile and keyFile are
+// given.
+func serve(srv *http.Server, certFile, keyFile string,
+	grace time.Duration) error {
+	// The context is canceled when one of the signals is received.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+	// The server runs in its own goroutine, and reports the error that stopped
+	// it on a channel; the channel is buffered, so the goroutine can always
+	// send, even when nobody receives anymore.
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	// The select statement waits for the first of its cases that can proceed.
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// After stop, a second signal kills the program at once.
+	stop()
+	slog.Info("shutting down", "grace", grace)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := accessLog(logger, http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET",
+		"/view/Old?rev=1", nil))
+	var record struct {
+		Msg, Method, URI string
+		Status           int
+		Bytes            int64
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, out.Bytes())
+	}
+	if record.Msg != "request" || record.Method != "GET" ||
+		record.URI != "/view/Old?rev=1" || record.Status != http.StatusGone ||
+		record.Bytes != 5 {
+		t.Errorf("access log record %+v", record)
+	}
+}
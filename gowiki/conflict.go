@@ -0,0 +1,120 @@
+package gowiki
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Saves of the same page are serialized by a mutex for each title, so that
+// checking the latest revision and adding the new one happen as a single step.
+// The mutexes are created on demand and dropped when nobody holds or waits for
+// them, so the map does not grow with every title ever saved.
+// Every write to the store holds the lock of the page, so the map is also the
+// list of the writes in progress: drainWrites waits, using the condition idle,
+// until it is empty.
+type titleLockTable struct {
+	mu    sync.Mutex
+	locks map[string]*titleLock
+	idle  *sync.Cond
+}
+
+func newTitleLockTable() *titleLockTable {
+	t := &titleLockTable{locks: make(map[string]*titleLock)}
+	t.idle = sync.NewCond(&t.mu)
+	return t
+}
+
+type titleLock struct {
+	sync.Mutex
+	refs int
+}
+
+// The method lockTitle locks the page with the given title and returns the
+// function that unlocks it.
+func (wk *Wiki) lockTitle(title string) func() {
+	wk.titleLocks.mu.Lock()
+	l := wk.titleLocks.locks[title]
+	if l == nil {
+		l = &titleLock{}
+		wk.titleLocks.locks[title] = l
+	}
+	l.refs++
+	wk.titleLocks.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		wk.titleLocks.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(wk.titleLocks.locks, title)
+			if len(wk.titleLocks.locks) == 0 {
+				wk.titleLocks.idle.Broadcast()
+			}
+		}
+		wk.titleLocks.mu.Unlock()
+	}
+}
+
+// The method drainWrites waits until no page is being written, so the
+// program can exit without leaving a save half done.
+func (wk *Wiki) drainWrites() {
+	wk.titleLocks.mu.Lock()
+	defer wk.titleLocks.mu.Unlock()
+	// Wait unlocks the mutex while it waits, and locks it again before
+	// returning, so the condition must be checked again.
+	for len(wk.titleLocks.locks) > 0 {
+		wk.titleLocks.idle.Wait()
+	}
+}
+
+// A conflictError is returned when a page is saved on top of a revision that
+// is no longer the latest one: somebody else saved the page in the meantime.
+type conflictError struct {
+	Base   int
+	Latest *Page
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%s was changed since revision %d", e.Latest.Title,
+		e.Base)
+}
+
+// The method conflictHandler answers a save rejected because of a conflict:
+// it shows what the other editor changed and a three-way merge of the two
+// edits, which the user can fix and save again on top of the latest revision.
+func (wk *Wiki) conflictHandler(w http.ResponseWriter, r *http.Request,
+	mine *Page, conflict *conflictError) {
+	base := &Page{Title: mine.Title}
+	if conflict.Base > 0 {
+		var err error
+		base, err = wk.store.LoadRevision(mine.Title, conflict.Base)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			base = &Page{Title: mine.Title}
+		}
+	}
+	theirs := conflict.Latest
+	merged, conflicts := merge3(string(base.Body), string(mine.Body),
+		string(theirs.Body), "your edit",
+		fmt.Sprintf("revision %d by %s", theirs.Number, theirs.Author))
+	// The status 409 Conflict tells the client that the request could not be
+	// completed because of the current state of the resource.
+	wk.renderStatus(w, http.StatusConflict, "conflict", struct {
+		Title     string
+		Base      int
+		Latest    *Page
+		Hunks     []diffHunk
+		Merged    string
+		Conflicts bool
+		Comment   string
+		CSRF      string
+	}{mine.Title, conflict.Base, theirs,
+		unifiedDiff(string(base.Body), string(theirs.Body), 3), merged,
+		conflicts, mine.Comment, wk.csrfToken(w, r)})
+}
@@ -0,0 +1,72 @@
+package gowiki
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// Cross-site request forgery (CSRF) is a page of another site submitting a
+// form to the wiki: the browser sends the cookies of the wiki with it, so the
+// wiki would take it as coming from the user. To tell its own forms apart,
+// the wiki gives each browser a random token in a cookie, and writes the same
+// token in a hidden field of every form that changes something: a forged
+// form cannot know the token, since other sites cannot read the cookies of
+// the wiki nor its pages.
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf"
+)
+
+// The method csrfToken returns the token of the browser sending the request,
+// giving it a new one if it has none yet. The forms rendered by the templates
+// carry it in the hidden field named "csrf".
+func (wk *Wiki) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	// Read of crypto/rand never fails on the supported platforms.
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	// The cookie lasts as long as the browser session, which is longer than
+	// any form stays open.
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     wk.basePath + "/",
+		HttpOnly: true,
+		Secure:   wk.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// The method checkCSRF tells whether the form submitted with the request
+// carries the token of the browser; if not, it answers with an error page.
+// The form must have been parsed already, or be small enough for FormValue to
+// parse it.
+func (wk *Wiki) checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err == nil && c.Value != "" && subtle.ConstantTimeCompare(
+		[]byte(c.Value), []byte(r.PostFormValue(csrfField))) == 1 {
+		return true
+	}
+	wk.httpError(w, "The form has expired or did not come from this wiki: "+
+		"go back, reload the page and submit it again.",
+		http.StatusForbidden)
+	return false
+}
+
+// The method requirePost tells whether the request uses the method POST; if
+// not, it answers with the status 405 Method Not Allowed.
+func (wk *Wiki) requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodPost)
+	wk.httpError(w, "This address only accepts forms submitted with POST.",
+		http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,50 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSaveForm(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	wk.acl = []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}}
+	save := wk.makeHandler(wk.saveHandler)
+	post := func(form url.Values, token string) int {
+		r := httptest.NewRequest("POST", "/save/FrontPage",
+			strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		}
+		w := httptest.NewRecorder()
+		save(w, r)
+		return w.Code
+	}
+	form := url.Values{"base": {"0"}, "body": {"Hello"}, "csrf": {"t0k3n"}}
+
+	w := httptest.NewRecorder()
+	save(w, httptest.NewRequest("GET", "/save/FrontPage?"+form.Encode(), nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if got := post(form, ""); got != http.StatusForbidden {
+		t.Errorf("no cookie: status %d, want %d", got, http.StatusForbidden)
+	}
+	if got := post(form, "other"); got != http.StatusForbidden {
+		t.Errorf("wrong token: status %d, want %d", got, http.StatusForbidden)
+	}
+	if got := post(form, "t0k3n"); got != http.StatusFound {
+		t.Errorf("valid form: status %d, want %d", got, http.StatusFound)
+	}
+
+	wk.maxBodySize = 100
+	form.Set("base", "1")
+	form.Set("body", strings.Repeat("x", 200))
+	if got := post(form, "t0k3n"); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: status %d, want %d", got,
+			http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,263 @@
+package gowiki
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A diffLine is a line of a unified diff: Op is ' ' for a line common to both
+// texts, '-' for a line found only in the old text and '+' for a line found
+// only in the new one.
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// A diffHunk is a group of changed lines together with some unchanged lines
+// around them, headed by the range of lines it covers in each text, as in the
+// output of "diff -u".
+type diffHunk struct {
+	Header string
+	Lines  []diffLine
+}
+
+// The function splitLines splits a text into lines, dropping the line
+// terminators; a final newline does not make an empty last line.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Past this number of lines in either text, once the lines common to their
+// beginnings and ends are set aside, the texts are not compared line by line:
+// the remaining lines of the old text are all removed, and those of the new
+// text all added. The result is still a correct edit script, only not the
+// shortest one, and no page can make a diff slow.
+const maxDiffLines = 3000
+
+// The function diffLines compares two sequences of lines and returns the edit
+// script that turns a into b.
+// The lines common to the beginnings and to the ends of the sequences are set
+// aside first, as most edits change a few lines of a page. The rest is compared
+// with the algorithm of Eugene Myers ("An O(ND) Difference Algorithm and Its
+// Variations", 1986), in its linear space variant: it takes time proportional
+// to (len(a)+len(b))*D, where D is the number of lines added and removed, and
+// memory proportional to len(a)+len(b).
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var script []diffLine
+	for _, l := range a[:prefix] {
+		script = append(script, diffLine{' ', l})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma) > maxDiffLines || len(mb) > maxDiffLines {
+		for _, l := range ma {
+			script = append(script, diffLine{'-', l})
+		}
+		for _, l := range mb {
+			script = append(script, diffLine{'+', l})
+		}
+	} else {
+		// The arrays of the furthest paths are shared by all the steps.
+		size := len(ma) + len(mb) + 4
+		d := &differ{a: ma, b: mb, script: script,
+			forward: make([]int, size), backward: make([]int, size)}
+		d.compare(0, len(ma), 0, len(mb))
+		script = d.script
+	}
+	for _, l := range a[len(a)-suffix:] {
+		script = append(script, diffLine{' ', l})
+	}
+	return script
+}
+
+// A differ holds the state of a comparison by diffLines: the sequences, the
+// edit script built so far, and the furthest paths found by middleSnake.
+type differ struct {
+	a, b              []string
+	script            []diffLine
+	forward, backward []int
+}
+
+// The method compare appends to the script the edits turning a[a0:a1] into
+// b[b0:b1]. It splits the problem in two at the middle of a shortest edit
+// script, found by middleSnake, and solves both halves in turn.
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.script = append(d.script, diffLine{' ', d.a[a0]})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1 > a0 && b1 > b0 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+		suffix++
+	}
+	switch {
+	case a0 == a1:
+		for _, l := range d.b[b0:b1] {
+			d.script = append(d.script, diffLine{'+', l})
+		}
+	case b0 == b1:
+		for _, l := range d.a[a0:a1] {
+			d.script = append(d.script, diffLine{'-', l})
+		}
+	default:
+		// Both ranges are left with different first and last lines, so at
+		// least two edits are needed, and each half needs fewer.
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.compare(a0, x, b0, y)
+		for _, l := range d.a[x:u] {
+			d.script = append(d.script, diffLine{' ', l})
+		}
+		d.compare(u, a1, v, b1)
+	}
+	for _, l := range d.a[a1 : a1+suffix] {
+		d.script = append(d.script, diffLine{' ', l})
+	}
+}
+
+// The method middleSnake returns the snake (a run of common lines, possibly
+// empty) from (x, y) to (u, v) in the middle of a shortest edit script turning
+// a[a0:a1] into b[b0:b1].
+// The search goes forward from the beginnings and backward from the ends at
+// the same time, one more edit at each step, until the paths meet. Along the
+// diagonal k, where x-y = k, forward[off+k] is the furthest x reached from the
+// beginnings; backward[off+k] is the furthest distance to the ends reached
+// along the diagonal of the reversed sequences, where the diagonal k of the
+// original ones is delta-k.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	steps := (n + m + 1) / 2
+	off := steps + 1
+	forward, backward := d.forward[:2*steps+3], d.backward[:2*steps+3]
+	forward[off+1], backward[off+1] = 0, 0
+	for e := 0; e <= steps; e++ {
+		for k := -e; k <= e; k += 2 {
+			// The path comes down from diagonal k+1 (a line added) or
+			// across from diagonal k-1 (a line removed), whichever got
+			// further.
+			var x int
+			if k == -e || k != e && forward[off+k-1] < forward[off+k+1] {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+			if odd && delta-k >= -(e-1) && delta-k <= e-1 &&
+				x+backward[off+delta-k] >= n {
+				return a0 + sx, b0 + sy, a0 + x, b0 + y
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || k != e && backward[off+k-1] < backward[off+k+1] {
+				x = backward[off+k+1]
+			} else {
+				x = backward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			backward[off+k] = x
+			if !odd && delta-k >= -e && delta-k <= e &&
+				x+forward[off+delta-k] >= n {
+				return a1 - x, b1 - y, a1 - sx, b1 - sy
+			}
+		}
+	}
+	// The paths always meet within steps steps.
+	panic("diff: no middle snake")
+}
+
+// The function unifiedDiff returns the hunks of the unified diff between two
+// texts, keeping context unchanged lines around each change. Changes closer
+// than twice the context are merged in the same hunk.
+func unifiedDiff(from, to string, context int) []diffHunk {
+	script := diffLines(splitLines(from), splitLines(to))
+	var hunks []diffHunk
+	for start := 0; start < len(script); {
+		// Look for the next change.
+		first := start
+		for first < len(script) && script[first].Op == ' ' {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		// Extend the hunk until a run of more than 2*context unchanged lines,
+		// or the end of the script.
+		last := first
+		for k := first; k < len(script); k++ {
+			if script[k].Op != ' ' {
+				last = k
+			} else if k-last > 2*context {
+				break
+			}
+		}
+		begin := max(first-context, start)
+		end := min(last+context+1, len(script))
+		hunks = append(hunks, makeHunk(script, begin, end))
+		start = end
+	}
+	return hunks
+}
+
+// The function makeHunk builds the hunk for script[begin:end], computing the
+// header from the lines that precede it.
+func makeHunk(script []diffLine, begin, end int) diffHunk {
+	// Line numbers in the header are 1-based.
+	oldStart, newStart := 1, 1
+	for _, l := range script[:begin] {
+		if l.Op != '+' {
+			oldStart++
+		}
+		if l.Op != '-' {
+			newStart++
+		}
+	}
+	var oldLen, newLen int
+	for _, l := range script[begin:end] {
+		if l.Op != '+' {
+			oldLen++
+		}
+		if l.Op != '-' {
+			newLen++
+		}
+	}
+	// By convention, an empty range starts at the line before it.
+	if oldLen == 0 {
+		oldStart--
+	}
+	if newLen == 0 {
+		newStart--
+	}
+	return diffHunk{
+		Header: fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldLen,
+			newStart, newLen),
+		Lines: script[begin:end],
+	}
+}
@@ -0,0 +1,95 @@
+package gowiki
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	hunks := unifiedDiff(from, to, 1)
+	want := []diffHunk{
+		{"@@ -1,3 +1,3 @@", []diffLine{{' ', "a"}, {'-', "b"}, {'+', "B"},
+			{' ', "c"}}},
+		{"@@ -10,1 +10,2 @@", []diffLine{{' ', "j"}, {'+', "k"}}},
+	}
+	if !reflect.DeepEqual(hunks, want) {
+		t.Fatalf("unifiedDiff() = %v, want %v", hunks, want)
+	}
+	if hunks := unifiedDiff(from, from, 3); hunks != nil {
+		t.Fatalf("unifiedDiff() of equal texts = %v, want none", hunks)
+	}
+}
+
+// The function lcsLength returns the length of the longest common
+// subsequence of a and b, by dynamic programming.
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+func TestDiffLines(t *testing.T) {
+	// The texts are made of a few distinct lines, so they have many lines in
+	// common in many ways.
+	rnd := rand.New(rand.NewPCG(1, 2))
+	text := func() []string {
+		lines := make([]string, rnd.IntN(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rnd.IntN(4)))
+		}
+		return lines
+	}
+	for range 500 {
+		a, b := text(), text()
+		var gotA, gotB []string
+		edits := 0
+		for _, l := range diffLines(a, b) {
+			if l.Op != '+' {
+				gotA = append(gotA, l.Text)
+			}
+			if l.Op != '-' {
+				gotB = append(gotB, l.Text)
+			}
+			if l.Op != ' ' {
+				edits++
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("diffLines(%q, %q) does not turn a into b", a, b)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, edits,
+				want)
+		}
+	}
+
+	// Past maxDiffLines, the lines in the middle are replaced as a whole.
+	a := make([]string, maxDiffLines+3)
+	b := make([]string, maxDiffLines+3)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint("a", i), fmt.Sprint("b", i)
+	}
+	a[0], b[0] = "same", "same"
+	script := diffLines(a, b)
+	if len(script) != 2*len(a)-1 || script[0].Op != ' ' ||
+		script[1] != (diffLine{'-', "a1"}) ||
+		script[len(a)] != (diffLine{'+', "b1"}) {
+		t.Errorf("diffLines() of large texts: %d lines", len(script))
+	}
+}
@@ -0,0 +1,170 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A pageEvent tells the readers and the editors of a page that it changed.
+type pageEvent struct {
+	Type     string    `json:"type"` // "save", "delete" or "rename"
+	Title    string    `json:"title"`
+	Revision int       `json:"revision,omitempty"`
+	Author   string    `json:"author,omitempty"`
+	Time     time.Time `json:"time,omitzero"`
+	To       string    `json:"to,omitempty"` // the new title, for "rename"
+}
+
+// An eventBroker passes the events of each page to the clients listening to
+// it. Each client receives them on its own channel; a client too slow to keep
+// up loses events instead of blocking the saves.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan pageEvent]struct{}
+	// done is closed when the server shuts down, to end the streams.
+	done chan struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subs: make(map[string]map[chan pageEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// The method subscribe returns a channel receiving the events of a page, and
+// the function to call when they are not wanted anymore.
+func (b *eventBroker) subscribe(title string) (<-chan pageEvent, func()) {
+	ch := make(chan pageEvent, 8)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[title] == nil {
+		b.subs[title] = make(map[chan pageEvent]struct{})
+	}
+	b.subs[title][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[title], ch)
+		if len(b.subs[title]) == 0 {
+			delete(b.subs, title)
+		}
+	}
+}
+
+// The method publish sends an event to the clients listening to its page.
+func (b *eventBroker) publish(e pageEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[e.Title] {
+		// A select with a default case does not block: if the buffer of the
+		// channel is full, the event is dropped.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// The method shutdown ends all the streams; the server calls it when it shuts
+// down, since it would otherwise wait for them to end.
+func (b *eventBroker) shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
+
+// How often a comment is sent on idle streams, so proxies do not close them.
+const eventKeepAlive = 25 * time.Second
+
+// The method eventsHandler streams the events of a page as server-sent
+// events (a response that never ends, made of "event:" and "data:" lines,
+// which browsers read with EventSource); it handles URLs prefixed with
+// "/events/".
+func (wk *Wiki) eventsHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	// A ResponseController gives access to features of the ResponseWriter
+	// that are not part of its interface: here, flushing the response and
+	// lifting the write timeout of the server for this request.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil &&
+		!errors.Is(err, http.ErrNotSupported) {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ch, cancel := wk.events.subscribe(title)
+	defer cancel()
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		// The context of the request is canceled when the client goes away.
+		case <-r.Context().Done():
+			return
+		case <-wk.events.done:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// The method renderBody renders the body of a page as the view page shows
+// it.
+func (wk *Wiki) renderBody(title string, body []byte) (template.HTML, error) {
+	exists, err := wk.pageExists()
+	if err != nil {
+		return "", err
+	}
+	return wk.renderMarkdown(title, body, exists), nil
+}
+
+// The method previewHandler renders a draft of a page, sent by the edit form
+// in the form values "title" and "body", without saving it; it handles the
+// URL "/preview". The response is the HTML of the body only, which the form
+// shows next to the text.
+func (wk *Wiki) previewHandler(w http.ResponseWriter, r *http.Request) {
+	if !wk.requirePost(w, r) || !wk.parseForm(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	title, ok := canonicalTitle(r.FormValue("title"))
+	if !ok {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	if !wk.allow(w, r, title, AccessRead) {
+		return
+	}
+	html, err := wk.renderBody(title, []byte(r.FormValue("body")))
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, html)
+}
@@ -0,0 +1,58 @@
+package gowiki
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	srv := httptest.NewServer(wk.makeHandler(wk.eventsHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/events/FrontPage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	// The headers are received once the handler has subscribed.
+	p := &Page{Title: "FrontPage", Body: []byte("Hello"),
+		Revision: Revision{Author: "alice"}}
+	if err := wk.save(p); err != nil {
+		t.Fatal(err)
+	}
+	s := bufio.NewScanner(resp.Body)
+	var lines []string
+	for len(lines) < 2 && s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if len(lines) < 2 || lines[0] != "event: save" ||
+		!strings.Contains(lines[1], `"revision":1,"author":"alice"`) {
+		t.Errorf("event %q", lines)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	form := url.Values{"title": {"Draft"}, "csrf": {"t0k3n"},
+		"body": {"---\nstatus: draft\n---\nSee FrontPage."}}
+	r := httptest.NewRequest("POST", "/preview",
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	w := httptest.NewRecorder()
+	wk.previewHandler(w, r)
+	want := `<p>See <a class="wikilink missing" href="/edit/FrontPage">FrontPage</a>.</p>`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("preview = %q, want %q", got, want)
+	}
+	if _, err := wk.loadPage("Draft"); err == nil {
+		t.Error("preview saved the draft")
+	}
+}
@@ -0,0 +1,213 @@
+package gowiki
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An exportPage holds the data shown by the export template: a page of the
+// wiki, or the index of the exported pages (with a nil Page and the Titles).
+// Its Title hides the one of the Page, so the template can use it in both
+// cases.
+type exportPage struct {
+	*Page
+	Title       string
+	HTML        template.HTML
+	Crumbs      []crumb
+	Backlinks   []string
+	Attachments []Attachment
+	Titles      []string
+	Redirect    string
+	// RedirectText is set instead of Redirect when the target of a redirect
+	// stub was not exported.
+	RedirectText string
+	Exported     time.Time
+}
+
+// The method ExportSite writes a static copy of the wiki to the directory
+// dir: a file <title>.html for each page, the attached files under "files",
+// the index of the pages, the style sheet of the default theme and a sitemap.
+// Only the pages that anonymous readers are allowed to read are exported; the
+// links to the others are written as plain text.
+func (wk *Wiki) ExportSite(dir string) error {
+	all, err := wk.store.List()
+	if err != nil {
+		return err
+	}
+	var titles []string
+	exported := make(map[string]bool)
+	for _, title := range all {
+		if wk.access(nil, title) >= AccessRead {
+			titles = append(titles, title)
+			exported[title] = true
+		}
+	}
+	exists := func(title string) bool { return exported[title] }
+	now := wk.clock()
+	sitemap := urlSet{}
+	for _, title := range titles {
+		p, err := wk.loadPage(title)
+		if err != nil {
+			return err
+		}
+		attachments, err := wk.store.Attachments(title)
+		if err != nil {
+			return err
+		}
+		for _, a := range attachments {
+			_, data, err := wk.store.LoadAttachment(title, a.Name)
+			if err != nil {
+				return err
+			}
+			if err := writeExport(dir, "files/"+title+"/"+a.Name, data); err != nil {
+				return err
+			}
+		}
+		data := exportPage{Page: p, Title: title, Crumbs: breadcrumbs(title),
+			Attachments: attachments, Exported: now}
+		if target, ok := redirectTarget(p.Body); ok && exported[target] {
+			data.Redirect = target
+		} else if ok {
+			data.RedirectText = target
+		} else {
+			data.HTML = wk.renderStatic(title, p.Body, exists)
+		}
+		for _, source := range wk.links.backlinks(title) {
+			if exported[source] {
+				data.Backlinks = append(data.Backlinks, source)
+			}
+		}
+		if err := wk.exportTemplate(dir, exportName(title), data); err != nil {
+			return err
+		}
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{
+			Loc:     wk.exportURL(exportName(title)),
+			LastMod: p.Time.UTC().Format(time.RFC3339),
+		})
+	}
+	err = wk.exportTemplate(dir, "index.html", exportPage{Title: "Index",
+		Titles: titles, Exported: now})
+	if err != nil {
+		return err
+	}
+	css, err := wk.readAsset("themes/" + wk.defaultTheme + ".css")
+	if err != nil {
+		return err
+	}
+	if err := writeExport(dir, "theme.css", css); err != nil {
+		return err
+	}
+	if wk.baseURL == "" {
+		log.Print("the sitemap has relative URLs: set -base-url to the " +
+			"address of the exported site")
+	}
+	out, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeExport(dir, "sitemap.xml", append([]byte(xml.Header), out...))
+}
+
+// The function exportName returns the name of the file of an exported page,
+// <title>.html. The page "index" is the exception, as its file would replace
+// the index of the pages: it is written to "index.page.html", which no other
+// page can use, since titles have no dots.
+func exportName(title string) string {
+	if title == "index" {
+		return "index.page.html"
+	}
+	return title + ".html"
+}
+
+// The types below describe a sitemap (https://www.sitemaps.org/), the list of
+// the pages of a site that search engines read.
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// The method exportURL returns the URL of an exported file, absolute if the
+// base URL of the site is known.
+func (wk *Wiki) exportURL(name string) string {
+	u := (&url.URL{Path: name}).EscapedPath()
+	if wk.baseURL == "" {
+		return u
+	}
+	return strings.TrimSuffix(wk.baseURL, "/") + "/" + u
+}
+
+// The method exportTemplate renders the export template, makes its links
+// relative, and writes it to the file name of dir.
+func (wk *Wiki) exportTemplate(dir, name string, data exportPage) error {
+	page, err := wk.executeTemplate("export", data)
+	if err != nil {
+		return err
+	}
+	return writeExport(dir, name, wk.relativeLinks(page, name))
+}
+
+// The function writeExport writes the file name (a slash separated path) of
+// dir, creating its directories.
+func writeExport(dir, name string, data []byte) error {
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// The links written by the templates are absolute paths of the wiki, such as
+// "/view/Team/Runbook", found in the attributes href and src.
+var localLink = regexp.MustCompile(`(href|src)="(/[^"]*)"`)
+
+// The method relativeLinks rewrites the links of the exported file name to
+// the exported files, relative to name, so the site works wherever it is
+// copied, even opened from the disk.
+func (wk *Wiki) relativeLinks(page []byte, name string) []byte {
+	from := path.Dir(name)
+	return localLink.ReplaceAllFunc(page, func(m []byte) []byte {
+		sub := localLink.FindSubmatch(m)
+		// The template escaped the URL for HTML: it is unescaped before
+		// parsing.
+		u, err := url.Parse(html.UnescapeString(string(sub[2])))
+		if err != nil {
+			return m
+		}
+		var target string
+		switch p := strings.TrimPrefix(u.Path, wk.basePath); {
+		case strings.HasPrefix(p, "/view/"):
+			target = exportName(strings.TrimPrefix(p, "/view/"))
+		case strings.HasPrefix(p, "/files/"):
+			target = strings.TrimPrefix(p, "/")
+		case p == "/theme.css":
+			target = "theme.css"
+		case p == "/" || strings.HasPrefix(p, "/index/"):
+			target = "index.html"
+		default:
+			return m
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(from),
+			filepath.FromSlash(target))
+		if err != nil {
+			return m
+		}
+		v := &url.URL{Path: filepath.ToSlash(rel), Fragment: u.Fragment}
+		return fmt.Appendf(nil, `%s="%s"`, sub[1],
+			html.EscapeString(v.String()))
+	})
+}
@@ -0,0 +1,87 @@
+package gowiki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativeLinks(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	testcases := []struct {
+		name, in, want string
+	}{
+		{"FrontPage.html", `<a href="/view/Team/Runbook">`,
+			`<a href="Team/Runbook.html">`},
+		{"Team/Runbook.html", `<a href="/view/FrontPage?rev=2#top">`,
+			`<a href="../FrontPage.html#top">`},
+		{"Team/Runbook.html", `<img src="/files/Team/Runbook/a.png">`,
+			`<img src="../files/Team/Runbook/a.png">`},
+		{"Team/Runbook.html", `<a href="/view/Caf%c3%a9">`,
+			`<a href="../Caf%C3%A9.html">`},
+		{"Team/Runbook.html", `<a href="/index/Team">`,
+			`<a href="../index.html">`},
+		{"FrontPage.html", `<a href="/view/index">`,
+			`<a href="index.page.html">`},
+		{"Team/Runbook.html", `<a href="https://example.com/view/X">`,
+			`<a href="https://example.com/view/X">`},
+	}
+	for _, tc := range testcases {
+		got := string(wk.relativeLinks([]byte(tc.in), tc.name))
+		if got != tc.want {
+			t.Errorf("relativeLinks(%q, %q) = %q, want %q", tc.in, tc.name,
+				got, tc.want)
+		}
+	}
+}
+
+func TestExportSite(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	wk.acl = []ACLRule{{Prefix: "Secret", Who: "*", Level: AccessNone}}
+	for _, p := range []*Page{
+		{Title: "FrontPage", Body: []byte("See [[Team/Runbook]].\n")},
+		{Title: "Team/Runbook", Body: []byte("Back to FrontPage.\n")},
+		{Title: "Secret", Body: []byte("hidden\n")},
+		{Title: "index", Body: []byte("Not [[Secret]] nor [[Missing]].\n")},
+	} {
+		if err := wk.save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	if err := wk.ExportSite(dir); err != nil {
+		t.Fatal(err)
+	}
+	page, err := os.ReadFile(filepath.Join(dir, "Team", "Runbook.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`href="../FrontPage.html"`,
+		`href="../theme.css"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("Team/Runbook.html has no %s:\n%s", want, page)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Secret.html")); err == nil {
+		t.Error("unreadable page exported")
+	}
+	// The page "index" does not replace the index of the pages, and the links
+	// to the pages not exported are plain text.
+	index, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil || !strings.Contains(string(index), `href="index.page.html"`) {
+		t.Errorf("index.html:\n%s", index)
+	}
+	page, err = os.ReadFile(filepath.Join(dir, "index.page.html"))
+	if err != nil || !strings.Contains(string(page), "Not Secret nor Missing.") ||
+		strings.Contains(string(page), "/edit/") {
+		t.Errorf("index.page.html:\n%s", page)
+	}
+	sitemap, err := os.ReadFile(filepath.Join(dir, "sitemap.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(sitemap), "<url>") != 3 {
+		t.Errorf("sitemap:\n%s", sitemap)
+	}
+}
@@ -0,0 +1,8 @@
+module example.com/gowiki
+
+go 1.24.0
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.52
+	golang.org/x/text v0.34.0
+)
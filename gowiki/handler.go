@@ -0,0 +1,274 @@
+package gowiki
+
+import (
+	"cmp"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Options holds what New assembles a wiki from. Only Store is required: the
+// zero values of the other fields turn off what they control, or select the
+// defaults given below.
+type Options struct {
+	// Store keeps the pages (see OpenStore).
+	Store PageStore
+	// Templates, if not nil, overrides the built-in templates and themes
+	// with its files "templates/*.html" and "themes/*.css".
+	Templates fs.FS
+	// Theme is the theme used when the reader has not chosen one; if empty,
+	// it is "default".
+	Theme string
+	// Clock returns the current time, which the wiki records in the
+	// revisions, sessions and leases; if nil, time.Now is used.
+	Clock func() time.Time
+	// Prefix is the path the handler is mounted under, such as "/wiki"; it
+	// must be mounted at the pattern Prefix + "/".
+	Prefix string
+	// BaseURL is the absolute URL of the wiki, used in the feeds and the
+	// notifications; if empty, the feeds take it from the request.
+	BaseURL string
+	// Users holds the accounts (see LoadUserDB); if nil, there are none.
+	Users *UserDB
+	// ACL holds the access control rules (see LoadACL). When no rule
+	// applies, anybody can read and the users logged in can edit too;
+	// administrators can always do anything.
+	ACL []ACLRule
+	// Blocklist lists the links and the patterns refused in the saves (see
+	// LoadBlocklist); if nil, nothing is refused.
+	Blocklist *Blocklist
+	// Moderate tells which saves by anonymous editors are held for
+	// moderation: "off" (or "") for none, "links" for those adding links to
+	// other sites, "all" for all of them.
+	Moderate string
+	// Moderation holds the saves waiting for moderation (see
+	// LoadModerationQueue); if nil, they are kept in memory only.
+	Moderation *ModerationQueue
+	// Watches holds the watchlists of the users (see LoadWatchDB); if nil,
+	// they are kept in memory only.
+	Watches *WatchDB
+	// Notifiers deliver the notifications of the saves to the users watching
+	// the pages; if empty, no notifications are sent.
+	Notifiers []Notifier
+	// SaveRateIP and SaveRateUser are the numbers of saves allowed each
+	// minute from an address, and by a user logged in; 0 means no limit.
+	SaveRateIP, SaveRateUser int
+	// MaxBodySize is the largest body of a form or of an API request, in
+	// bytes; if 0, it is 1 MiB.
+	MaxBodySize int64
+	// MaxUploadSize is the largest file that can be attached to a page, in
+	// bytes; if 0, it is 10 MiB.
+	MaxUploadSize int64
+}
+
+// A Wiki serves the pages of a store over HTTP. It holds everything its
+// handlers share, so a program may serve several wikis, each under its own
+// prefix.
+type Wiki struct {
+	store        PageStore
+	templateFS   fs.FS
+	templates    *templateSet
+	defaultTheme string
+	clock        func() time.Time
+	// The URLs written by the wiki start with basePath, while the handlers
+	// see the paths without it.
+	basePath      string
+	baseURL       string
+	users         *UserDB
+	sessions      *sessionDB
+	acl           []ACLRule
+	blocked       *Blocklist
+	moderate      string
+	moderation    *ModerationQueue
+	watches       *WatchDB
+	notifications *notifyQueue
+	saveRateIP    int
+	saveRateUser  int
+	// The limiters of the saves, by address and by user, and of the failed
+	// logins, by address.
+	ipLimiter     *rateLimiter
+	userLimiter   *rateLimiter
+	loginLimiter  *rateLimiter
+	maxBodySize   int64
+	maxUploadSize int64
+	// The indexes built from the pages when the wiki is created, and kept up
+	// to date by the saves.
+	links    *linkGraph
+	index    *searchIndex
+	recent   *recentLog
+	tags     *tagIndex
+	rendered *renderCache
+	// The state of the pages being edited.
+	titleLocks *titleLockTable
+	leases     *leaseTable
+	events     *eventBroker
+	mux        *http.ServeMux
+}
+
+// The function New returns the wiki built from the pages of the store: it
+// loads the templates, builds the links graph, the search index, the recent
+// changes and the tags, and registers the handlers on a ServeMux of its own,
+// so a program can serve the wiki next to its other handlers.
+func New(o Options) (*Wiki, error) {
+	if o.Store == nil {
+		return nil, errors.New("no page store")
+	}
+	if o.Prefix != "" && (o.Prefix[0] != '/' ||
+		strings.HasSuffix(o.Prefix, "/")) {
+		return nil, errors.New(`the prefix must start with "/", and not end ` +
+			`with it`)
+	}
+	switch o.Moderate {
+	case "":
+		o.Moderate = "off"
+	case "off", "links", "all":
+	default:
+		return nil, errors.New("unknown moderation " + o.Moderate)
+	}
+	wk := &Wiki{
+		store:         o.Store,
+		templateFS:    o.Templates,
+		templates:     &templateSet{},
+		defaultTheme:  cmp.Or(o.Theme, "default"),
+		clock:         o.Clock,
+		basePath:      o.Prefix,
+		baseURL:       o.BaseURL,
+		users:         o.Users,
+		sessions:      &sessionDB{sessions: make(map[string]session)},
+		acl:           o.ACL,
+		blocked:       cmp.Or(o.Blocklist, &Blocklist{}),
+		moderate:      o.Moderate,
+		moderation:    cmp.Or(o.Moderation, &ModerationQueue{}),
+		watches:       o.Watches,
+		saveRateIP:    o.SaveRateIP,
+		saveRateUser:  o.SaveRateUser,
+		ipLimiter:     newRateLimiter(),
+		userLimiter:   newRateLimiter(),
+		loginLimiter:  newRateLimiter(),
+		maxBodySize:   cmp.Or(o.MaxBodySize, 1<<20),
+		maxUploadSize: cmp.Or(o.MaxUploadSize, 10<<20),
+		titleLocks:    newTitleLockTable(),
+		leases:        &leaseTable{leases: make(map[string]lease)},
+		events:        newEventBroker(),
+		mux:           http.NewServeMux(),
+	}
+	if wk.clock == nil {
+		wk.clock = time.Now
+	}
+	if wk.users == nil {
+		wk.users = &UserDB{users: make(map[string]*User)}
+	}
+	if wk.watches == nil {
+		wk.watches = &WatchDB{lists: make(map[string][]string)}
+	}
+	wk.rendered = newRenderCache(wk.clock)
+	if err := wk.reloadTemplates(); err != nil {
+		return nil, err
+	}
+	if !wk.validTheme(wk.defaultTheme) {
+		return nil, errors.New("unknown theme " + wk.defaultTheme)
+	}
+	var err error
+	if wk.links, err = wk.buildLinkGraph(wk.store); err != nil {
+		return nil, err
+	}
+	if wk.index, err = buildSearchIndex(wk.store); err != nil {
+		return nil, err
+	}
+	if wk.recent, err = buildRecentLog(wk.store, recentSize); err != nil {
+		return nil, err
+	}
+	if wk.tags, err = buildTagIndex(wk.store); err != nil {
+		return nil, err
+	}
+	wk.registerHandlers(wk.mux, o.Prefix)
+	// The queue starts its goroutine, so it is made last, when nothing can
+	// fail anymore.
+	wk.notifications = newNotifyQueue(o.Notifiers)
+	return wk, nil
+}
+
+// The method ServeHTTP makes the wiki an http.Handler. The user of the
+// request is known before any handler runs (see withUser).
+func (wk *Wiki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, err := wk.withUser(r)
+	if err != nil {
+		wk.refuseSave(w, err)
+		return
+	}
+	wk.mux.ServeHTTP(w, r)
+}
+
+// The method EndStreams ends the event streams of the readers (see
+// events.go), which would otherwise keep their requests open; a server
+// shutting down calls it first (see http.Server.RegisterOnShutdown).
+func (wk *Wiki) EndStreams() {
+	wk.events.shutdown()
+}
+
+// The method Close ends the event streams, waits until no page is being
+// written, and delivers the notifications of the last saves; the program
+// can then exit without leaving a save half done. The store is left open: it
+// belongs to the caller.
+func (wk *Wiki) Close() {
+	wk.events.shutdown()
+	wk.drainWrites()
+	wk.notifications.stop()
+}
+
+// The method registerHandlers registers the handlers of the wiki on mux,
+// under prefix.
+func (wk *Wiki) registerHandlers(mux *http.ServeMux, prefix string) {
+	// The prefix is added to the patterns, rather than removed from the
+	// requests before the ServeMux sees them, so that the redirects of the
+	// ServeMux (from "/index" to "/index/", for instance) keep it; StripPrefix
+	// removes it before calling each handler.
+	handle := func(pattern string, h http.HandlerFunc) {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = "", pattern
+		}
+		mux.Handle(strings.TrimSpace(method+" "+prefix+path),
+			http.StripPrefix(prefix, h))
+	}
+	handle("/view/", wk.makeHandler(wk.viewHandler))
+	handle("/edit/", wk.makeHandler(wk.editHandler))
+	handle("/save/", wk.makeHandler(wk.saveHandler))
+	handle("/history/", wk.makeHandler(wk.historyHandler))
+	handle("/diff/", wk.makeHandler(wk.diffHandler))
+	handle("/revert/", wk.makeHandler(wk.revertHandler))
+	handle("/backlinks/", wk.makeHandler(wk.backlinksHandler))
+	handle("/upload/", wk.makeHandler(wk.uploadHandler))
+	handle("/rename/", wk.makeHandler(wk.renameHandler))
+	handle("/delete/", wk.makeHandler(wk.deleteHandler))
+	handle("/lease/", wk.makeHandler(wk.leaseHandler))
+	handle("/unlock/", wk.makeHandler(wk.unlockHandler))
+	handle("/events/", wk.makeHandler(wk.eventsHandler))
+	handle("/watch/", wk.makeHandler(wk.watchHandler))
+	handle("/watchlist", wk.watchlistHandler)
+	handle("/preview", wk.previewHandler)
+	handle("GET /files/{path...}", wk.fileHandler)
+	handle("/orphans", wk.orphansHandler)
+	handle("/search", wk.searchHandler)
+	handle("/recent", wk.recentHandler)
+	handle("GET /tags/{tag...}", wk.tagsHandler)
+	handle("GET /feed.atom", wk.feedHandler)
+	handle("/login", wk.loginHandler)
+	handle("/logout", wk.logoutHandler)
+	handle("/{$}", wk.rootHandler)
+	handle("GET /theme.css", wk.themeCSSHandler)
+	handle("/theme", wk.themeHandler)
+	handle("/moderation", wk.moderationHandler)
+	handle("GET /admin/export", wk.adminExportHandler)
+	// Patterns may start with an HTTP method, and may contain wildcards such as
+	// {title}, whose value is returned by the method PathValue of the request.
+	handle("GET /api/pages", wk.apiListHandler)
+	// A wildcard ending in "..." matches the rest of the path, slashes
+	// included.
+	handle("GET /api/pages/{title...}", wk.apiGetHandler)
+	handle("PUT /api/pages/{title...}", wk.apiPutHandler)
+	handle("DELETE /api/pages/{title...}", wk.apiDeleteHandler)
+	handle("GET /index/{namespace...}", wk.indexHandler)
+}
@@ -0,0 +1,195 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The function newTestWiki returns the wiki built by New from o, and closes
+// it when the test ends.
+func newTestWiki(t *testing.T, o Options) *Wiki {
+	t.Helper()
+	wk, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(wk.Close)
+	return wk
+}
+
+// The function do sends a request to the handler; a form is posted with a
+// valid CSRF token.
+func do(h http.Handler, method, target string,
+	form url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form == nil {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		form.Set("csrf", "t0k3n")
+		r = httptest.NewRequest(method, target,
+			strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	wk := newTestWiki(t, Options{Store: s, Prefix: "/wiki",
+		Clock: func() time.Time { return start }})
+
+	for _, test := range []struct {
+		method, target string
+		status         int
+		location       string
+	}{
+		{"GET", "/wiki/", http.StatusFound, "/wiki/view/FrontPage"},
+		{"GET", "/wiki/view/Missing", http.StatusFound, "/wiki/edit/Missing"},
+		{"GET", "/wiki/view/Team/Release%20Plan", http.StatusMovedPermanently,
+			"/wiki/view/Team/ReleasePlan"},
+		{"GET", "/wiki/index", http.StatusTemporaryRedirect, "/wiki/index/"},
+		{"GET", "/wiki/edit/FrontPage", http.StatusFound,
+			"/wiki/login?next=%2Fedit%2FFrontPage"},
+		{"GET", "/wiki/view/Bad..Title", http.StatusNotFound, ""},
+		{"GET", "/wiki/nothing/FrontPage", http.StatusNotFound, ""},
+		{"GET", "/view/FrontPage", http.StatusNotFound, ""},
+		{"POST", "/wiki/save/FrontPage", http.StatusForbidden, ""},
+	} {
+		w := do(wk, test.method, test.target, nil)
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: status %d, location %q, want %d, %q", test.method,
+				test.target, w.Code, w.Header().Get("Location"), test.status,
+				test.location)
+		}
+	}
+
+	w := do(wk, "GET", "/wiki/view/FrontPage", nil)
+	if body := w.Body.String(); w.Code != http.StatusOK ||
+		!strings.Contains(body, "Welcome") ||
+		!strings.Contains(body, `href="/wiki/history/FrontPage"`) {
+		t.Errorf("view: status %d, body %s", w.Code, body)
+	}
+
+	wk.acl = []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}}
+	form := url.Values{"base": {"0"}, "body": {"Hello, FrontPage"}}
+	w = do(wk, "POST", "/wiki/save/NewPage", form)
+	if w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "/wiki/view/NewPage" {
+		t.Fatalf("save: status %d, location %q", w.Code,
+			w.Header().Get("Location"))
+	}
+	p, err := s.Load("NewPage")
+	if err != nil || string(p.Body) != "Hello, FrontPage" ||
+		!p.Time.Equal(start) {
+		t.Fatalf("saved page %v, %v", p, err)
+	}
+	w = do(wk, "GET", "/wiki/view/NewPage", nil)
+	if !strings.Contains(w.Body.String(), `href="/wiki/view/FrontPage"`) {
+		t.Errorf("view after save: %s", w.Body.String())
+	}
+	// A save based on an old revision shows the conflict page.
+	w = do(wk, "POST", "/wiki/save/NewPage", form)
+	if w.Code != http.StatusConflict {
+		t.Errorf("stale save: status %d, want %d", w.Code,
+			http.StatusConflict)
+	}
+}
+
+func TestHandlerTemplates(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	broken := fstest.MapFS{"templates/view.html": {
+		Data: []byte(`{{define "content"}}{{.Nope`)}}
+	if _, err := New(Options{Store: s, Templates: broken}); err == nil {
+		t.Error("broken template loaded")
+	}
+
+	// A template failing when executed results in an error page, without
+	// any part of the page.
+	failing := fstest.MapFS{"templates/view.html": {
+		Data: []byte(`{{define "content"}}{{.Title.Nope}}{{end}}`)}}
+	wk := newTestWiki(t, Options{Store: s, Templates: failing})
+	w := do(wk, "GET", "/view/FrontPage", nil)
+	if w.Code != http.StatusInternalServerError ||
+		strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("status %d, body %s", w.Code, w.Body.String())
+	}
+	w = do(wk, "GET", "/edit/FrontPage", nil)
+	if w.Code != http.StatusFound {
+		t.Errorf("other templates: status %d", w.Code)
+	}
+}
+
+func TestTwoWikis(t *testing.T) {
+	// Two wikis, each with its own store, under two prefixes of one
+	// ServeMux.
+	a, b := newMemStore(), newMemStore()
+	a.Save(&Page{Title: "FrontPage", Body: []byte("Wiki A")})
+	b.Save(&Page{Title: "FrontPage", Body: []byte("Wiki B")})
+	everyone := []ACLRule{{Prefix: "", Who: "*", Level: AccessEdit}}
+	mux := http.NewServeMux()
+	mux.Handle("/a/",
+		newTestWiki(t, Options{Store: a, Prefix: "/a", ACL: everyone}))
+	mux.Handle("/b/", newTestWiki(t, Options{Store: b, Prefix: "/b"}))
+
+	for _, test := range []struct{ target, body string }{
+		{"/a/view/FrontPage", "Wiki A"},
+		{"/b/view/FrontPage", "Wiki B"},
+	} {
+		w := do(mux, "GET", test.target, nil)
+		if w.Code != http.StatusOK ||
+			!strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: status %d, body %s", test.target, w.Code,
+				w.Body.String())
+		}
+	}
+	// The access rules, the link graph and the index of one wiki are not
+	// those of the other.
+	form := url.Values{"base": {"0"}, "body": {"Links to FrontPage"}}
+	w := do(mux, "POST", "/a/save/NewPage", form)
+	if w.Code != http.StatusFound {
+		t.Fatalf("save in a: status %d", w.Code)
+	}
+	w = do(mux, "POST", "/b/save/NewPage", form)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("save in b: status %d, want %d", w.Code,
+			http.StatusForbidden)
+	}
+	if _, err := b.Load("NewPage"); err == nil {
+		t.Error("page saved in a found in b")
+	}
+	w = do(mux, "GET", "/b/backlinks/FrontPage", nil)
+	if strings.Contains(w.Body.String(), "NewPage") {
+		t.Errorf("backlinks of b list a page of a: %s", w.Body.String())
+	}
+}
+
+func TestClose(t *testing.T) {
+	wk, err := New(Options{Store: newMemStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock := wk.lockTitle("FrontPage")
+	done := make(chan bool)
+	go func() {
+		wk.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Close returned during a write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+}
@@ -0,0 +1,155 @@
+package gowiki
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// The method requestAuthor returns the name recorded as the author of the
+// revisions saved by the request: the name of the user logged in or, for an
+// anonymous request, the address of the client.
+func (wk *Wiki) requestAuthor(r *http.Request) string {
+	if u := wk.currentUser(r); u != nil {
+		return u.Name
+	}
+	return clientAddress(r)
+}
+
+// The function revisionParam returns the revision number in the form value
+// with the given name, or def if the value is missing.
+func revisionParam(r *http.Request, name string, def int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid revision %q", v)
+	}
+	return n, nil
+}
+
+// The method historyHandler lists the revisions of a page; it handles URLs
+// prefixed with "/history/".
+func (wk *Wiki) historyHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	history, err := wk.store.History(title)
+	if errors.Is(err, ErrNotFound) {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// An anonymous struct is handy to pass more than one value to a template.
+	wk.renderTemplate(w, "history", struct {
+		Title     string
+		Revisions []Revision
+		CSRF      string
+	}{title, history, wk.csrfToken(w, r)})
+}
+
+// The method diffHandler shows the differences between two revisions of a
+// page; it handles URLs prefixed with "/diff/", with the revision numbers in
+// the query parameters "from" and "to". By default, it compares the latest
+// revision with the previous one; revision 0 stands for the empty page.
+func (wk *Wiki) diffHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	latest, err := wk.loadPage(title)
+	if errors.Is(err, ErrNotFound) {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	to, err := revisionParam(r, "to", latest.Number)
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := revisionParam(r, "from", max(to-1, 0))
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var pages [2]*Page
+	for i, n := range []int{from, to} {
+		if n == 0 {
+			pages[i] = &Page{Title: title}
+			continue
+		}
+		pages[i], err = wk.store.LoadRevision(title, n)
+		if errors.Is(err, ErrNotFound) {
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	wk.renderTemplate(w, "diff", struct {
+		Title    string
+		From, To *Page
+		Hunks    []diffHunk
+	}{title, pages[0], pages[1],
+		unifiedDiff(string(pages[0].Body), string(pages[1].Body), 3)})
+}
+
+// The method revertHandler restores an older revision of a page, given in
+// the form value "rev", by saving its body as a new revision; it handles URLs
+// prefixed with "/revert/". The history is never rewritten, so a revert can
+// itself be reverted.
+func (wk *Wiki) revertHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	// Reverting changes the page, so it must not be triggered by a simple link
+	// that a browser or a crawler could follow.
+	if !wk.requirePost(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	n, err := revisionParam(r, "rev", 0)
+	if err != nil || n == 0 {
+		wk.httpError(w, "The revision to revert to is missing or invalid.",
+			http.StatusBadRequest)
+		return
+	}
+	old, err := wk.store.LoadRevision(title, n)
+	if errors.Is(err, ErrNotFound) {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	latest, err := wk.loadPage(title)
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p := &Page{Title: title, Body: old.Body, Revision: Revision{
+		Author:  wk.requestAuthor(r),
+		Comment: fmt.Sprintf("Revert to revision %d", n),
+	}}
+	// A revert is a save like the others, refused or held by the same
+	// controls (see abuse.go).
+	err = wk.saveAs(p, wk.requestEditor(r), latest.Number)
+	var conflict *conflictError
+	switch {
+	case wk.refuseSave(w, err):
+		return
+	case errors.As(err, &conflict):
+		wk.httpError(w, "The page was changed meanwhile: try again.",
+			http.StatusConflict)
+		return
+	case err != nil:
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, wk.pageURL("view", title), http.StatusFound)
+}
@@ -0,0 +1,99 @@
+package gowiki
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Besides rejecting stale saves (see conflict.go), the wiki tells editors when
+// somebody else is editing the same page. Opening the edit form takes a lease
+// on the page: a soft lock, held by an editor until it expires, that does not
+// prevent others from editing, but shows them a warning. The edit form renews
+// the lease while it is open, by posting to "/lease/<title>"; saving the page
+// releases it, and admins can break it.
+type lease struct {
+	Holder  string
+	Expires time.Time
+}
+
+// How long a lease lasts without being renewed, and how often the edit form
+// renews it.
+const (
+	leaseDuration  = 2 * time.Minute
+	leaseHeartbeat = 30 * time.Second
+)
+
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+// The method acquire takes or renews the lease on a page for holder, unless
+// somebody else holds it; it returns the lease on the page, and whether it is
+// held by holder.
+func (t *leaseTable) acquire(title, holder string, now time.Time) (lease,
+	bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// The expired leases are dropped, so the table does not grow with every
+	// page ever edited.
+	for k, l := range t.leases {
+		if now.After(l.Expires) {
+			delete(t.leases, k)
+		}
+	}
+	if l, ok := t.leases[title]; ok && l.Holder != holder {
+		return l, false
+	}
+	l := lease{holder, now.Add(leaseDuration)}
+	t.leases[title] = l
+	return l, true
+}
+
+// The method release drops the lease on a page, if it is held by holder.
+func (t *leaseTable) release(title, holder string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.leases[title].Holder == holder {
+		delete(t.leases, title)
+	}
+}
+
+// The method breakLease drops the lease on a page, whoever holds it.
+func (t *leaseTable) breakLease(title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.leases, title)
+}
+
+// The method leaseHandler renews the lease of the editor on a page; it
+// handles POST requests to URLs prefixed with "/lease/", sent by the edit
+// form. The response is the lease as JSON, with the status 409 Conflict if it
+// is held by somebody else.
+func (wk *Wiki) leaseHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	if !wk.requirePost(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	l, ok := wk.leases.acquire(title, wk.requestAuthor(r), wk.clock())
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, struct {
+		Holder  string    `json:"holder"`
+		Expires time.Time `json:"expires"`
+	}{l.Holder, l.Expires})
+}
+
+// The method unlockHandler breaks the lease on a page, and goes back to its
+// edit form; it handles URLs prefixed with "/unlock/", for admins only.
+func (wk *Wiki) unlockHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	if !wk.requirePost(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	wk.leases.breakLease(title)
+	http.Redirect(w, r, wk.pageURL("edit", title), http.StatusFound)
+}
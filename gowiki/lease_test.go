@@ -0,0 +1,36 @@
+package gowiki
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	table := &leaseTable{leases: make(map[string]lease)}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, ok := table.acquire("Runbook", "alice", now); !ok {
+		t.Fatal("alice did not get the free lease")
+	}
+	l, ok := table.acquire("Runbook", "bob", now.Add(time.Minute))
+	if ok || l.Holder != "alice" {
+		t.Errorf("bob got the lease held by alice: %+v", l)
+	}
+	// Renewing moves the expiry forward.
+	l, ok = table.acquire("Runbook", "alice", now.Add(time.Minute))
+	if !ok || !l.Expires.Equal(now.Add(time.Minute+leaseDuration)) {
+		t.Errorf("alice renewed the lease as %+v, %v", l, ok)
+	}
+	table.release("Runbook", "bob")
+	if _, ok := table.acquire("Runbook", "bob", now.Add(time.Minute)); ok {
+		t.Error("bob released the lease of alice")
+	}
+	// An expired lease is free.
+	later := now.Add(time.Minute + leaseDuration + time.Second)
+	if l, ok := table.acquire("Runbook", "bob", later); !ok || l.Holder != "bob" {
+		t.Errorf("bob did not get the expired lease: %+v", l)
+	}
+	table.breakLease("Runbook")
+	if l, ok := table.acquire("Runbook", "carol", later); !ok {
+		t.Errorf("carol did not get the broken lease: %+v", l)
+	}
+}
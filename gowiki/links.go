@@ -0,0 +1,154 @@
+package gowiki
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// A linkGraph records which pages link to which, so the wiki can tell who
+// references a page without reading every page. It is built from the page store
+// at startup and updated by each save.
+// For each page, out holds the set of pages it links to and in the set of pages
+// linking to it; both are kept so that updating the links of a page does not
+// require a scan of the whole graph.
+type linkGraph struct {
+	mu  sync.RWMutex
+	out map[string]map[string]struct{}
+	in  map[string]map[string]struct{}
+}
+
+func newLinkGraph() *linkGraph {
+	return &linkGraph{
+		out: make(map[string]map[string]struct{}),
+		in:  make(map[string]map[string]struct{}),
+	}
+}
+
+// The method buildLinkGraph reads every page in the store and records its
+// links.
+func (wk *Wiki) buildLinkGraph(s PageStore) (*linkGraph, error) {
+	g := newLinkGraph()
+	titles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		p, err := s.Load(title)
+		if err != nil {
+			return nil, err
+		}
+		g.update(title, wk.pageLinks(p.Body))
+	}
+	return g, nil
+}
+
+// The method pageLinks returns the titles of the pages linked from a body.
+// It renders the body with the same renderer used by the view page, so the
+// graph always agrees with the links the readers see.
+func (wk *Wiki) pageLinks(body []byte) []string {
+	var titles []string
+	wk.renderMarkdown("", body, func(title string) bool {
+		titles = append(titles, title)
+		return true
+	})
+	return titles
+}
+
+// The method update replaces the outgoing links of a page.
+func (g *linkGraph) update(title string, targets []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(title)
+	set := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		set[target] = struct{}{}
+		if g.in[target] == nil {
+			g.in[target] = make(map[string]struct{})
+		}
+		g.in[target][title] = struct{}{}
+	}
+	g.out[title] = set
+}
+
+// The method remove forgets the outgoing links of a page that has been
+// deleted; the links to it are kept, as they are still in the other pages.
+func (g *linkGraph) remove(title string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(title)
+}
+
+// The method removeLocked must be called with the write lock held.
+func (g *linkGraph) removeLocked(title string) {
+	for target := range g.out[title] {
+		delete(g.in[target], title)
+		if len(g.in[target]) == 0 {
+			delete(g.in, target)
+		}
+	}
+	delete(g.out, title)
+}
+
+// The method backlinks returns the titles of the pages linking to a page, in
+// alphabetical order.
+func (g *linkGraph) backlinks(title string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var titles []string
+	for source := range g.in[title] {
+		if source != title {
+			titles = append(titles, source)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// The method orphans returns the titles of the existing pages that no other
+// page links to, in alphabetical order.
+func (g *linkGraph) orphans() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var titles []string
+	for title := range g.out {
+		linked := false
+		for source := range g.in[title] {
+			if source != title {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// The method backlinksHandler lists the pages linking to a page; it handles
+// URLs prefixed with "/backlinks/".
+func (wk *Wiki) backlinksHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	wk.renderTemplate(w, "pages", pageList{
+		Heading: "Pages linking to " + title,
+		Titles:  wk.readable(r, wk.links.backlinks(title)),
+	})
+}
+
+// The method orphansHandler lists the pages that no other page links to; it
+// handles the URL "/orphans".
+func (wk *Wiki) orphansHandler(w http.ResponseWriter, r *http.Request) {
+	wk.renderTemplate(w, "pages", pageList{
+		Heading: "Orphaned pages",
+		Titles:  wk.readable(r, wk.links.orphans()),
+	})
+}
+
+// A pageList holds the data shown by the pages template: a list of titles
+// under a heading.
+type pageList struct {
+	Heading string
+	Titles  []string
+}
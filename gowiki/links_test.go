@@ -0,0 +1,24 @@
+package gowiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinkGraph(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	g := newLinkGraph()
+	g.update("HomePage", wk.pageLinks([]byte("See [[Guide]] and FooBar.")))
+	g.update("Guide", wk.pageLinks([]byte("Back to HomePage, or Guide.")))
+	g.update("FooBar", nil)
+	if got, want := g.backlinks("Guide"), []string{"HomePage"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf(`backlinks("Guide") = %q, want %q`, got, want)
+	}
+	g.update("HomePage", wk.pageLinks([]byte("Only [Guide] now.")))
+	if got := g.backlinks("FooBar"); got != nil {
+		t.Fatalf(`backlinks("FooBar") = %q, want none`, got)
+	}
+	if got, want := g.orphans(), []string{"FooBar"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("orphans() = %q, want %q", got, want)
+	}
+}
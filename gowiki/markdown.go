@@ -0,0 +1,423 @@
+package gowiki
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Page bodies are written in a subset of Markdown:
+//
+//   - headings (# to ######), paragraphs, horizontal rules (---),
+//   - unordered (-, * or +) and ordered (1.) lists, block quotes (>),
+//   - fenced code blocks (```) and code spans (`code`),
+//   - emphasis (*em*) and strong emphasis (**strong**),
+//   - links [text](url) and images ![alt](url),
+//   - files attached to the page, embedded as ![[file.png]] (or as
+//     ![[Other/Page/file.png]] for a file attached to another page): images
+//     are shown, other files are linked,
+//
+// plus links to other pages of the wiki, written as [PageName], [[Page Name]]
+// or just as a WikiWord (two or more capitalized words run together); a
+// WikiWord preceded by ! is not linked.
+//
+// Raw HTML is not supported: every character of the body is escaped, and the
+// only markup in the result is the one generated by the renderer, with URLs
+// limited to safe schemes. That is what makes the result safe to embed in a
+// page as template.HTML, which html/template does not escape again.
+
+// The method renderMarkdown converts the body of the page with the given
+// title to HTML; exists tells whether a page exists, so links to missing pages
+// can point to the edit page instead.
+func (wk *Wiki) renderMarkdown(title string, src []byte,
+	exists func(title string) bool) template.HTML {
+	r := &markdownRenderer{wiki: wk, title: title, exists: exists}
+	return r.render(src)
+}
+
+// The method renderStatic converts a body to HTML for the static export
+// (see export.go), which has no edit pages: the links to missing pages are
+// written as plain text.
+func (wk *Wiki) renderStatic(title string, src []byte,
+	exists func(title string) bool) template.HTML {
+	r := &markdownRenderer{wiki: wk, title: title, exists: exists,
+		static: true}
+	return r.render(src)
+}
+
+type markdownRenderer struct {
+	wiki   *Wiki
+	title  string
+	exists func(title string) bool
+	// The function external, if not nil, is called with the URL of each
+	// link or image leading to another site.
+	external func(u *url.URL)
+	static   bool
+	b        strings.Builder
+}
+
+func (r *markdownRenderer) render(src []byte) template.HTML {
+	// The front matter holds the metadata of the page, shown apart.
+	_, src = splitFrontMatter(src)
+	r.blocks(splitLines(string(src)))
+	return template.HTML(r.b.String())
+}
+
+var (
+	headingLine = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ruleLine    = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	bulletItem  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedItem = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	wikiWord    = regexp.MustCompile(`^\p{Lu}[\p{Ll}\p{N}]+(?:\p{Lu}[\p{Ll}\p{N}]+)+`)
+)
+
+// The method blocks renders a sequence of lines as block elements.
+func (r *markdownRenderer) blocks(lines []string) {
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			r.b.WriteString("<p>")
+			r.inline(strings.Join(para, "\n"))
+			r.b.WriteString("</p>\n")
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			r.b.WriteString("<pre><code>")
+			for i++; i < len(lines) &&
+				!strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				r.b.WriteString(html.EscapeString(lines[i]))
+				r.b.WriteByte('\n')
+			}
+			r.b.WriteString("</code></pre>\n")
+		case headingLine.MatchString(trimmed):
+			flush()
+			m := headingLine.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			r.b.WriteString("<" + tag + ">")
+			r.inline(m[2])
+			r.b.WriteString("</" + tag + ">\n")
+		case ruleLine.MatchString(line):
+			flush()
+			r.b.WriteString("<hr>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(t, ">") {
+					break
+				}
+				quote = append(quote, strings.TrimPrefix(t[1:], " "))
+			}
+			i--
+			r.b.WriteString("<blockquote>\n")
+			r.blocks(quote)
+			r.b.WriteString("</blockquote>\n")
+		case bulletItem.MatchString(line) && len(para) == 0:
+			i = r.list(lines, i, "ul", bulletItem)
+		case orderedItem.MatchString(line) && len(para) == 0:
+			i = r.list(lines, i, "ol", orderedItem)
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+}
+
+// The method list renders the list starting at lines[start], whose items match
+// item; an indented line following an item continues it. It returns the index
+// of the last line of the list.
+func (r *markdownRenderer) list(lines []string, start int, tag string,
+	item *regexp.Regexp) int {
+	r.b.WriteString("<" + tag + ">\n")
+	i := start
+	for i < len(lines) {
+		m := item.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		// The lines of the item are joined once they are all known: adding
+		// them to a string one by one would copy it again for each.
+		text := []string{m[1]}
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], " ") &&
+			strings.TrimSpace(lines[i+1]) != "" &&
+			!item.MatchString(lines[i+1]) {
+			i++
+			text = append(text, strings.TrimSpace(lines[i]))
+		}
+		r.b.WriteString("<li>")
+		r.inline(strings.Join(text, "\n"))
+		r.b.WriteString("</li>\n")
+		i++
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return i - 1
+}
+
+// The method inline renders the text of a block, escaping every character that
+// is not part of a recognized span.
+func (r *markdownRenderer) inline(s string) {
+	t := &inlineText{s: s}
+	for i := 0; i < len(s); {
+		if n := r.span(t, i, i == 0 || !isWordByte(s[i-1])); n > 0 {
+			i += n
+			continue
+		}
+		r.b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+// The method span renders the span at position i of the text, if any, and
+// returns the number of bytes it consumed (0 if no span starts there). atWord
+// tells whether i is at the beginning of a word.
+func (r *markdownRenderer) span(t *inlineText, i int, atWord bool) int {
+	s := t.s[i:]
+	switch {
+	case s[0] == '`':
+		if end := t.index("`", i+1); end >= 0 {
+			r.b.WriteString("<code>" + html.EscapeString(s[1:end+1]) +
+				"</code>")
+			return end + 2
+		}
+	case strings.HasPrefix(s, "**"):
+		if end := t.index("**", i+2); end > 0 {
+			r.b.WriteString("<strong>")
+			r.inline(s[2 : end+2])
+			r.b.WriteString("</strong>")
+			return end + 4
+		}
+	case s[0] == '*':
+		if end := t.index("*", i+1); end > 0 && s[1] != ' ' {
+			r.b.WriteString("<em>")
+			r.inline(s[1 : end+1])
+			r.b.WriteString("</em>")
+			return end + 2
+		}
+	case strings.HasPrefix(s, "![["):
+		if end := t.index("]]", i); end > 3 && r.attachment(s[3:end]) {
+			return end + 2
+		}
+	case strings.HasPrefix(s, "!["):
+		if text, target, n := t.link(i + 1); n > 0 {
+			r.b.WriteString(`<img src="` + r.href(target) + `" alt="` +
+				html.EscapeString(text) + `">`)
+			return n + 1
+		}
+	case strings.HasPrefix(s, "[["):
+		if end := t.index("]]", i); end > 2 {
+			if title := wikiTitle(s[2:end]); title != "" {
+				r.wikiLink(title, s[2:end])
+				return end + 2
+			}
+		}
+	case s[0] == '[':
+		if text, target, n := t.link(i); n > 0 {
+			r.b.WriteString(`<a href="` + r.href(target) + `">`)
+			r.inline(text)
+			r.b.WriteString("</a>")
+			return n
+		}
+		if end := t.index("]", i); end > 1 {
+			if title := wikiTitle(s[1:end]); title == s[1:end] {
+				r.wikiLink(title, title)
+				return end + 1
+			}
+		}
+	case s[0] == '!' && atWord:
+		// An escaped WikiWord is written as is, without the !.
+		if w := wikiWord.FindString(s[1:]); w != "" && wordEnds(s[1+len(w):]) {
+			r.b.WriteString(w)
+			return len(w) + 1
+		}
+	case atWord:
+		if w := wikiWord.FindString(s); w != "" && wordEnds(s[len(w):]) {
+			r.wikiLink(w, w)
+			return len(w)
+		}
+	}
+	return 0
+}
+
+// An inlineText is the text of a block, with what is known of the positions
+// of its closing delimiters. The spans are tried at each position in turn,
+// and a span that does not close would otherwise search the rest of the text
+// again at each of its openings, making the rendering quadratic: the searches
+// go forward only, and each delimiter is looked for past the last one found.
+type inlineText struct {
+	s string
+	// The map found holds, for each delimiter, the start of the last search
+	// and its result (-1 if there was none).
+	found map[string][2]int
+	// The map parens holds the position of the parenthesis closing each
+	// opening one; the unbalanced ones are missing.
+	parens map[int]int
+}
+
+// The method index returns the position of the first delim at or after from,
+// relative to from as strings.Index returns it, or -1 if there is none.
+func (t *inlineText) index(delim string, from int) int {
+	f, ok := t.found[delim]
+	if ok && f[0] <= from && (f[1] < 0 || f[1] >= from) {
+		// No delim starts between f[0] and f[1], or after f[0] at all.
+		if f[1] < 0 {
+			return -1
+		}
+		return f[1] - from
+	}
+	i := strings.Index(t.s[from:], delim)
+	if i >= 0 {
+		i += from
+	}
+	if t.found == nil {
+		t.found = make(map[string][2]int)
+	}
+	t.found[delim] = [2]int{from, i}
+	if i < 0 {
+		return -1
+	}
+	return i - from
+}
+
+// The method link parses a Markdown link "[text](target)" at position i,
+// returning its parts and its length (0 if no link starts there).
+// Parentheses in the target must be balanced, as in "f(x)": the target ends
+// at the parenthesis closing the one after "]".
+func (t *inlineText) link(i int) (text, target string, n int) {
+	if t.s[i] != '[' {
+		return "", "", 0
+	}
+	end := t.index("](", i)
+	if end < 0 {
+		return "", "", 0
+	}
+	end += i
+	if t.parens == nil {
+		// The parentheses are matched once for the whole text, with a stack
+		// of the positions of the ones still open.
+		t.parens = make(map[int]int)
+		var open []int
+		for j := 0; j < len(t.s); j++ {
+			switch t.s[j] {
+			case '(':
+				open = append(open, j)
+			case ')':
+				if len(open) > 0 {
+					t.parens[open[len(open)-1]] = j
+					open = open[:len(open)-1]
+				}
+			}
+		}
+	}
+	last, ok := t.parens[end+1]
+	if !ok {
+		return "", "", 0
+	}
+	return t.s[i+1 : end], strings.TrimSpace(t.s[end+2 : last]), last + 1 - i
+}
+
+// The method wikiLink writes a link to the page with the given title; links to
+// missing pages lead to their edit page and are marked with the class
+// "missing", or are just text in a static export.
+func (r *markdownRenderer) wikiLink(title, text string) {
+	switch {
+	case r.exists(title):
+		r.b.WriteString(`<a class="wikilink" href="` +
+			html.EscapeString(r.wiki.pageURL("view", title)) + `">`)
+	case r.static:
+		r.b.WriteString(html.EscapeString(text))
+		return
+	default:
+		r.b.WriteString(`<a class="wikilink missing" href="` +
+			html.EscapeString(r.wiki.pageURL("edit", title)) + `">`)
+	}
+	r.b.WriteString(html.EscapeString(text) + "</a>")
+}
+
+// The method attachment writes the embedding of an attached file, given as
+// "name" or "Page/Title/name"; it returns false if ref is not valid.
+func (r *markdownRenderer) attachment(ref string) bool {
+	title, name := r.title, ref
+	if i := strings.LastIndexByte(ref, '/'); i >= 0 {
+		title, name = wikiTitle(ref[:i]), ref[i+1:]
+	}
+	if title == "" || !validAttachmentName(name) {
+		return false
+	}
+	src := html.EscapeString(r.wiki.attachmentURL(title, name))
+	if isImage(name) {
+		r.b.WriteString(`<img src="` + src + `" alt="` +
+			html.EscapeString(name) + `">`)
+	} else {
+		r.b.WriteString(`<a class="attachment" href="` + src + `">` +
+			html.EscapeString(name) + "</a>")
+	}
+	return true
+}
+
+// The method href returns the escaped URL of a link or an image, or "#" if
+// the URL is not safe (see safeURL); the URLs leading to another site are
+// passed to r.external.
+func (r *markdownRenderer) href(raw string) string {
+	u := safeURL(raw)
+	if u == nil {
+		return "#"
+	}
+	if u.Host != "" && r.external != nil {
+		r.external(u)
+	}
+	return html.EscapeString(u.String())
+}
+
+// The function safeURL parses a URL, and returns it if its scheme is a safe
+// one (http, https, mailto, or none for a URL relative to the wiki or to its
+// scheme), or nil for anything else, such as a javascript: URL. An http or
+// https URL without "//", such as "https:example.com", is refused too: the
+// browsers read the host in it, which url.Parse does not.
+func safeURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "mailto":
+		return u
+	case "http", "https":
+		if u.Opaque == "" {
+			return u
+		}
+	}
+	return nil
+}
+
+// The function wikiTitle turns the name of a page as written in a link, such as
+// "Page Name", into its title, such as "PageName"; it returns "" if the name
+// does not make a valid title.
+func wikiTitle(name string) string {
+	title, _ := canonicalTitle(name)
+	return title
+}
+
+// The function isWordByte tells whether c may be part of a word. The bytes of
+// the UTF-8 encoding of non-ASCII characters are all counted as word bytes, so
+// a WikiWord is never recognized in the middle of a word such as "ÉtéWikiWord".
+func isWordByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' || c >= 0x80
+}
+
+// The function wordEnds tells whether the rest of the text after a word starts
+// with a non-word character, so the word is not a prefix of a longer one.
+func wordEnds(rest string) bool {
+	return rest == "" || !isWordByte(rest[0])
+}
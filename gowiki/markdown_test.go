@@ -0,0 +1,93 @@
+package gowiki
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	exists := func(title string) bool { return title == "HomePage" }
+	testcases := []struct {
+		in, want string
+	}{
+		{"# Title", "<h1>Title</h1>\n"},
+		{"one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"*a* **b** `<c>`",
+			"<p><em>a</em> <strong>b</strong> <code>&lt;c&gt;</code></p>\n"},
+		{"- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"<script>alert(1)</script>",
+			"<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"[x](javascript:alert(1))", `<p><a href="#">x</a>` + "</p>\n"},
+		{"[x](https:evil.example)", `<p><a href="#">x</a>` + "</p>\n"},
+		{"[x](//golang.org)", `<p><a href="//golang.org">x</a>` + "</p>\n"},
+		{"[x](http://golang.org)",
+			`<p><a href="http://golang.org">x</a>` + "</p>\n"},
+		{"(see [f](http://golang.org/f(x)))",
+			`<p>(see <a href="http://golang.org/f(x)">f</a>)` + "</p>\n"},
+		{"See HomePage.",
+			`<p>See <a class="wikilink" href="/view/HomePage">HomePage</a>.` +
+				"</p>\n"},
+		{"[[Other Page]]",
+			`<p><a class="wikilink missing" href="/edit/OtherPage">` +
+				"Other Page</a></p>\n"},
+		{"[HomePage] !NotLinked",
+			`<p><a class="wikilink" href="/view/HomePage">HomePage</a> ` +
+				"NotLinked</p>\n"},
+		{"```\n*raw*\n```", "<pre><code>*raw*\n</code></pre>\n"},
+		{"![[a.png]] ![[Other/log.txt]]",
+			`<p><img src="/files/HomePage/a.png" alt="a.png"> ` +
+				`<a class="attachment" href="/files/Other/log.txt">log.txt</a>` +
+				"</p>\n"},
+	}
+	for _, tc := range testcases {
+		got := string(wk.renderMarkdown("HomePage", []byte(tc.in), exists))
+		if got != tc.want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// Rendering takes a time linear in the length of the body: sixteen times more
+// text must not take much more than sixteen times longer, let alone the 256
+// times it would if each span that does not close searched the rest of the
+// text again.
+func TestRenderMarkdownLinear(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	exists := func(title string) bool { return false }
+	// The function elapsed returns the shortest of n renderings of src, the
+	// one least disturbed by the rest of the machine.
+	elapsed := func(src string, n int) time.Duration {
+		shortest := time.Duration(math.MaxInt64)
+		for range n {
+			start := time.Now()
+			wk.renderMarkdown("HomePage", []byte(src), exists)
+			shortest = min(shortest, time.Since(start))
+		}
+		return shortest
+	}
+	for _, tc := range []struct{ first, unit string }{
+		{"", "[a]"},
+		{"", "![["},
+		{"", "[["},
+		{"", "[a]("},
+		{"", "[a](()"},
+		{"", "**a"},
+		{"", "*"},
+		{"- a\n", "  b\n"},
+	} {
+		text := func(size int) string {
+			return tc.first + strings.Repeat(tc.unit, size/len(tc.unit))
+		}
+		small, large := elapsed(text(32<<10), 5), elapsed(text(512<<10), 2)
+		if large > 64*small {
+			t.Errorf("rendering %q repeated: %v for 32 KiB, %v for 512 KiB",
+				tc.unit, small, large)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gowiki
+
+import (
+	"slices"
+	"strings"
+)
+
+// The function lineMatches aligns two sequences of lines: for each line of a,
+// it returns the index of the same line in b, or -1 if the line was removed.
+// The alignment is the one of the edit script computed by diffLines.
+func lineMatches(a, b []string) []int {
+	matches := make([]int, len(a))
+	i, j := 0, 0
+	for _, l := range diffLines(a, b) {
+		switch l.Op {
+		case ' ':
+			matches[i] = j
+			i++
+			j++
+		case '-':
+			matches[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return matches
+}
+
+// The function merge3 merges the changes made to the text base by two editors,
+// giving mine and theirs, like "diff3 -m" does.
+// The lines of base kept by both editors split the texts in chunks. For each
+// chunk, if only one of the editors changed it, their version is taken; if
+// both changed it in the same way, that version is taken; otherwise the chunk
+// is a conflict, and both versions are written between conflict markers
+// labelled with mineLabel and theirsLabel. merge3 reports whether there was
+// any conflict.
+func merge3(base, mine, theirs, mineLabel, theirsLabel string) (string, bool) {
+	b, m, t := splitLines(base), splitLines(mine), splitLines(theirs)
+	bm, bt := lineMatches(b, m), lineMatches(b, t)
+	var out []string
+	conflicts := false
+	i, j, k := 0, 0, 0
+	for {
+		// Look for the next line of base kept by both editors.
+		s := i
+		for s < len(b) && (bm[s] < 0 || bt[s] < 0) {
+			s++
+		}
+		me, te := len(m), len(t)
+		if s < len(b) {
+			me, te = bm[s], bt[s]
+		}
+		cb, cm, ct := b[i:s], m[j:me], t[k:te]
+		switch {
+		case slices.Equal(cm, cb):
+			out = append(out, ct...)
+		case slices.Equal(ct, cb), slices.Equal(cm, ct):
+			out = append(out, cm...)
+		default:
+			conflicts = true
+			out = append(out, "<<<<<<< "+mineLabel)
+			out = append(out, cm...)
+			out = append(out, "=======")
+			out = append(out, ct...)
+			out = append(out, ">>>>>>> "+theirsLabel)
+		}
+		if s == len(b) {
+			break
+		}
+		out = append(out, b[s])
+		i, j, k = s+1, me+1, te+1
+	}
+	if len(out) == 0 {
+		return "", conflicts
+	}
+	return strings.Join(out, "\n") + "\n", conflicts
+}
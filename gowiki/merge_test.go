@@ -0,0 +1,42 @@
+package gowiki
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	base := "a\nb\nc\nd\n"
+	testcases := []struct {
+		mine, theirs, want string
+		conflicts          bool
+	}{
+		{"A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", false},
+		{"a\nb\nc\nd\ne\n", "a\nc\nd\n", "a\nc\nd\ne\n", false},
+		{"a\nB\nc\nd\n", "a\nB\nc\nd\n", "a\nB\nc\nd\n", false},
+		{"a\nX\nc\nd\n", "a\nY\nc\nd\n",
+			"a\n<<<<<<< mine\nX\n=======\nY\n>>>>>>> theirs\nc\nd\n", true},
+	}
+	for _, tc := range testcases {
+		got, conflicts := merge3(base, tc.mine, tc.theirs, "mine", "theirs")
+		if got != tc.want || conflicts != tc.conflicts {
+			t.Errorf("merge3(%q, %q, %q) = %q, %v, want %q, %v", base, tc.mine,
+				tc.theirs, got, conflicts, tc.want, tc.conflicts)
+		}
+	}
+}
+
+func TestSaveAt(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	err := wk.saveAt(&Page{Title: "Foo", Body: []byte("one")}, 0)
+	if err != nil {
+		t.Fatalf("saveAt(0) of a new page error = %v", err)
+	}
+	err = wk.saveAt(&Page{Title: "Foo", Body: []byte("two")}, 1)
+	if err != nil {
+		t.Fatalf("saveAt(1) error = %v", err)
+	}
+	err = wk.saveAt(&Page{Title: "Foo", Body: []byte("three")}, 1)
+	conflict, ok := err.(*conflictError)
+	if !ok || conflict.Latest.Number != 2 {
+		t.Fatalf("stale saveAt(1) error = %v, want a conflict with revision 2",
+			err)
+	}
+}
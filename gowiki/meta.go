@@ -0,0 +1,260 @@
+package gowiki
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The metadata of a page, such as its owner, status or tags, is written at the
+// start of the body as front matter, between two lines "---" (in YAML syntax)
+// or "+++" (in TOML syntax):
+//
+//	---
+//	owner: alice
+//	status: draft
+//	tags: [database, oncall]
+//	---
+//
+// Only the simplest forms of YAML and TOML are understood: one key and its
+// value per line, where the value may be a list in square brackets (or, in
+// YAML, the following lines starting with "- "). Every value is kept as a list
+// of strings, a single value being a list of one.
+type Meta map[string][]string
+
+// The function splitFrontMatter returns the metadata in the front matter of a
+// body, and the rest of the body; if the body has no front matter, the
+// metadata is nil and the rest is the whole body.
+func splitFrontMatter(body []byte) (Meta, []byte) {
+	var delim, sep string
+	switch {
+	case bytes.HasPrefix(body, []byte("---\n")),
+		bytes.HasPrefix(body, []byte("---\r\n")):
+		delim, sep = "---", ":"
+	case bytes.HasPrefix(body, []byte("+++\n")),
+		bytes.HasPrefix(body, []byte("+++\r\n")):
+		delim, sep = "+++", "="
+	default:
+		return nil, body
+	}
+	rest := body[bytes.IndexByte(body, '\n')+1:]
+	var lines []string
+	for len(rest) > 0 {
+		line := rest
+		next := []byte(nil)
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, next = rest[:i], rest[i+1:]
+		}
+		rest = next
+		if s := strings.TrimRight(string(line), " \t\r"); s == delim {
+			return parseMeta(lines, sep), rest
+		}
+		lines = append(lines, string(line))
+	}
+	// Without the closing line, the body has no front matter after all.
+	return nil, body
+}
+
+// The function parseMeta parses the lines of the front matter, whose keys are
+// separated from the values by sep.
+func parseMeta(lines []string, sep string) Meta {
+	meta := make(Meta)
+	key := ""
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		// A YAML list item adds a value to the last key.
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && key != "" &&
+			sep == ":" {
+			meta[key] = append(meta[key], unquote(item))
+			continue
+		}
+		k, v, ok := strings.Cut(line, sep)
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !ok || k == "" {
+			key = ""
+			continue
+		}
+		key = k
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			meta[key] = nil
+		case v[0] == '[' && v[len(v)-1] == ']':
+			var values []string
+			for _, item := range strings.Split(v[1:len(v)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, unquote(item))
+				}
+			}
+			meta[key] = values
+		default:
+			meta[key] = []string{unquote(v)}
+		}
+	}
+	return meta
+}
+
+// The function unquote removes the quotes around a value, if any.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// The method parseMeta sets the metadata of the page from its body.
+func (p *Page) parseMeta() {
+	p.Meta, _ = splitFrontMatter(p.Body)
+}
+
+// A tagIndex maps each tag to the set of the pages tagged with it, by the key
+// "tags" of their front matter. Like the links graph, it is built from the
+// page store at startup and updated by each save.
+type tagIndex struct {
+	mu    sync.RWMutex
+	pages map[string]map[string]struct{}
+	tags  map[string][]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		pages: make(map[string]map[string]struct{}),
+		tags:  make(map[string][]string),
+	}
+}
+
+// The function buildTagIndex reads every page in the store and records its
+// tags.
+func buildTagIndex(s PageStore) (*tagIndex, error) {
+	idx := newTagIndex()
+	titles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		p, err := s.Load(title)
+		if err != nil {
+			return nil, err
+		}
+		p.parseMeta()
+		idx.update(title, p.Meta["tags"])
+	}
+	return idx, nil
+}
+
+// The method update replaces the tags of a page.
+func (idx *tagIndex) update(title string, pageTags []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+	for _, tag := range pageTags {
+		if idx.pages[tag] == nil {
+			idx.pages[tag] = make(map[string]struct{})
+		}
+		idx.pages[tag][title] = struct{}{}
+	}
+	if len(pageTags) > 0 {
+		idx.tags[title] = pageTags
+	}
+}
+
+func (idx *tagIndex) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *tagIndex) removeLocked(title string) {
+	for _, tag := range idx.tags[title] {
+		delete(idx.pages[tag], title)
+		if len(idx.pages[tag]) == 0 {
+			delete(idx.pages, tag)
+		}
+	}
+	delete(idx.tags, title)
+}
+
+// The method tagged returns the sorted titles of the pages with a tag.
+func (idx *tagIndex) tagged(tag string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var titles []string
+	for title := range idx.pages[tag] {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// The method all returns the sorted list of the tags in use.
+func (idx *tagIndex) all() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var all []string
+	for tag := range idx.pages {
+		all = append(all, tag)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// The method tagsHandler lists the pages with a tag; it handles the URLs
+// "/tags/<tag>", and "/tags/", which lists the tags.
+func (wk *Wiki) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if tag == "" {
+		// Only the tags of pages the user can read are listed.
+		var all []string
+		for _, tag := range wk.tags.all() {
+			if len(wk.readable(r, wk.tags.tagged(tag))) > 0 {
+				all = append(all, tag)
+			}
+		}
+		wk.renderTemplate(w, "tags", struct{ Tags []string }{all})
+		return
+	}
+	wk.renderTemplate(w, "pages", pageList{
+		Heading: "Pages tagged " + tag,
+		Titles:  wk.readable(r, wk.tags.tagged(tag)),
+	})
+}
+
+// The pages whose body new pages can start from are kept in this namespace:
+// "/edit/<title>?template=Runbook" starts from the page "Templates/Runbook".
+const templateNamespace = "Templates"
+
+// The method templatePages returns the names of the page templates the user
+// of the request can read.
+func (wk *Wiki) templatePages(r *http.Request) []string {
+	titles, err := wk.store.List()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, title := range wk.readable(r, titles) {
+		if name, ok := strings.CutPrefix(title, templateNamespace+"/"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// The method templateBody returns the body of the named page template, if
+// it exists and the user of the request can read it.
+func (wk *Wiki) templateBody(r *http.Request, name string) ([]byte, bool) {
+	title, ok := canonicalTitle(templateNamespace + "/" + name)
+	if !ok || wk.access(wk.currentUser(r), title) < AccessRead {
+		return nil, false
+	}
+	p, err := wk.loadPage(title)
+	if err != nil {
+		return nil, false
+	}
+	return p.Body, true
+}
@@ -0,0 +1,59 @@
+package gowiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	testcases := []struct {
+		body string
+		meta Meta
+		rest string
+	}{
+		{"---\nOwner: alice\nstatus: 'draft'\ntags: [db, \"on call\"]\n---\n# Runbook\n",
+			Meta{"owner": {"alice"}, "status": {"draft"},
+				"tags": {"db", "on call"}}, "# Runbook\n"},
+		{"---\ntags:\n  - db\n  - ops\n# comment\n---\nBody\n",
+			Meta{"tags": {"db", "ops"}}, "Body\n"},
+		{"+++\nowner = \"bob\"\ntags = [\"ops\"]\n+++\nBody\n",
+			Meta{"owner": {"bob"}, "tags": {"ops"}}, "Body\n"},
+		{"---\nnot closed\n", nil, "---\nnot closed\n"},
+		{"Body\n---\nowner: x\n---\n", nil, "Body\n---\nowner: x\n---\n"},
+	}
+	for _, tc := range testcases {
+		meta, rest := splitFrontMatter([]byte(tc.body))
+		if !reflect.DeepEqual(meta, tc.meta) || string(rest) != tc.rest {
+			t.Errorf("splitFrontMatter(%q) = %v, %q, want %v, %q", tc.body,
+				meta, rest, tc.meta, tc.rest)
+		}
+	}
+}
+
+func TestTagIndex(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	for _, p := range []*Page{
+		{Title: "Deploy", Body: []byte("---\ntags: [ops, db]\n---\n")},
+		{Title: "Restore", Body: []byte("---\ntags: [db]\n---\n")},
+		{Title: "Deploy", Body: []byte("---\ntags: [ops]\n---\n")},
+	} {
+		if err := wk.save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := wk.tags.tagged("db")
+	if !reflect.DeepEqual(got, []string{"Restore"}) {
+		t.Errorf(`pages tagged "db" = %v`, got)
+	}
+	if _, err := wk.renamePage("Deploy", "Release",
+		editor{user: &User{Name: "alice"}}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	got = wk.tags.tagged("ops")
+	if !reflect.DeepEqual(got, []string{"Release"}) {
+		t.Errorf(`pages tagged "ops" after rename = %v`, got)
+	}
+	if got := wk.tags.all(); !reflect.DeepEqual(got, []string{"db", "ops"}) {
+		t.Errorf("tags = %v", got)
+	}
+}
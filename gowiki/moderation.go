@@ -0,0 +1,190 @@
+package gowiki
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// A heldSave is a save waiting for moderation: the page as the editor sent it,
+// the revision the edit started from, and why it was held.
+type heldSave struct {
+	ID    string
+	Title string
+	Body  string
+	Base  int
+	Revision
+	Reasons []string
+}
+
+// A ModerationQueue holds the saves waiting for moderation, oldest first, in a
+// JSON file, so they survive a restart.
+type ModerationQueue struct {
+	mu    sync.Mutex
+	path  string
+	saves []heldSave
+}
+
+// The function LoadModerationQueue reads the queue from the file at path; a
+// missing file is just an empty queue.
+func LoadModerationQueue(path string) (*ModerationQueue, error) {
+	q := &ModerationQueue{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.saves); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return q, nil
+}
+
+// The method write writes the queue to its file; the caller must hold the
+// lock.
+func (q *ModerationQueue) write() error {
+	return writeJSONFile(q.path, q.saves)
+}
+
+// The method hold adds a save to the queue, giving it a random ID.
+func (q *ModerationQueue) hold(h *heldSave) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	h.ID = hex.EncodeToString(b)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.saves = append(q.saves, *h)
+	return q.write()
+}
+
+func (q *ModerationQueue) list() []heldSave {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]heldSave(nil), q.saves...)
+}
+
+func (q *ModerationQueue) get(id string) (heldSave, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, h := range q.saves {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return heldSave{}, false
+}
+
+// The method remove drops a save from the queue.
+func (q *ModerationQueue) remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, h := range q.saves {
+		if h.ID == id {
+			q.saves = append(q.saves[:i], q.saves[i+1:]...)
+			return q.write()
+		}
+	}
+	return nil
+}
+
+// The method approve writes a held save to the store. If the page was saved
+// since the edit started, the edit is merged into the latest revision, as the
+// conflict page does; it returns a *conflictError if the merge has conflicts.
+func (wk *Wiki) approve(h heldSave) error {
+	p := &Page{Title: h.Title, Body: []byte(h.Body), Revision: Revision{
+		Author: h.Author, Comment: h.Comment}}
+	err := wk.saveAt(p, h.Base)
+	var conflict *conflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+	// The revision the edit started from is gone if the page was deleted
+	// meanwhile.
+	base, err := wk.store.LoadRevision(h.Title, h.Base)
+	if errors.Is(err, ErrNotFound) {
+		base, err = &Page{Title: h.Title}, nil
+	}
+	if err != nil {
+		return err
+	}
+	merged, conflicts := merge3(string(base.Body), h.Body,
+		string(conflict.Latest.Body), "held", "latest")
+	if conflicts {
+		return conflict
+	}
+	p.Body = []byte(merged)
+	return wk.saveAt(p, conflict.Latest.Number)
+}
+
+// A heldView holds the data shown for a held save by the moderation template:
+// the changes it makes to the latest revision of the page.
+type heldView struct {
+	heldSave
+	Hunks []diffHunk
+}
+
+// The method moderationHandler lists the saves held for moderation, and
+// approves or rejects them; it handles the URL "/moderation", for admins only.
+// The form posts the ID of the save in "id", and "approve" or "reject" in
+// "action".
+func (wk *Wiki) moderationHandler(w http.ResponseWriter, r *http.Request) {
+	if !wk.allow(w, r, "", AccessAdmin) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		if !wk.checkCSRF(w, r) {
+			return
+		}
+		h, ok := wk.moderation.get(r.FormValue("id"))
+		if !ok {
+			wk.httpError(w, "There is no such save.", http.StatusNotFound)
+			return
+		}
+		var err error
+		switch r.FormValue("action") {
+		case "approve":
+			err = wk.approve(h)
+		case "reject":
+		default:
+			wk.httpError(w, "Unknown action.", http.StatusBadRequest)
+			return
+		}
+		var conflict *conflictError
+		if errors.As(err, &conflict) {
+			wk.httpError(w, fmt.Sprintf("The edit conflicts with revision "+
+				"%d of %s: it can only be rejected.", conflict.Latest.Number,
+				h.Title), http.StatusConflict)
+			return
+		}
+		if err == nil {
+			err = wk.moderation.remove(h.ID)
+		}
+		if err != nil {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, wk.basePath+"/moderation", http.StatusFound)
+		return
+	}
+	var views []heldView
+	for _, h := range wk.moderation.list() {
+		latest := ""
+		if p, err := wk.loadPage(h.Title); err == nil {
+			latest = string(p.Body)
+		}
+		views = append(views, heldView{h, unifiedDiff(latest, h.Body, 3)})
+	}
+	wk.renderTemplate(w, "moderation", struct {
+		Saves []heldView
+		CSRF  string
+	}{views, wk.csrfToken(w, r)})
+}
@@ -0,0 +1,220 @@
+package gowiki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Notification tells a user that a page they watch was saved.
+type Notification struct {
+	User     string    `json:"user"`
+	Email    string    `json:"-"` // the address of the user, if known
+	Title    string    `json:"title"`
+	Revision int       `json:"revision"`
+	Author   string    `json:"author"`
+	Comment  string    `json:"comment,omitempty"`
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"` // absolute if Options.BaseURL is set
+}
+
+// A Notifier delivers notifications, by mail, to a web hook, or by any other
+// means a program embedding the wiki provides (see Options). Notify may block
+// while it delivers, and returns an error if it gave up.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// A notifyQueue delivers the notifications through its notifiers in a
+// goroutine of its own, so a save does not wait for mail servers and web
+// hooks. When the queue is full, new notifications are dropped.
+type notifyQueue struct {
+	notifiers []Notifier
+	mu        sync.Mutex
+	closed    bool
+	ch        chan Notification
+	// done is closed when the goroutine has delivered everything.
+	done chan struct{}
+}
+
+// The number of notifications the queue holds before dropping new ones.
+const notifyQueueSize = 1000
+
+// The function newNotifyQueue returns a queue delivering through notifiers,
+// and starts its goroutine; without notifiers, the queue drops everything.
+func newNotifyQueue(notifiers []Notifier) *notifyQueue {
+	q := &notifyQueue{notifiers: notifiers, done: make(chan struct{})}
+	if len(notifiers) == 0 {
+		q.closed = true
+		close(q.done)
+		return q
+	}
+	q.ch = make(chan Notification, notifyQueueSize)
+	go q.run()
+	return q
+}
+
+func (q *notifyQueue) run() {
+	defer close(q.done)
+	for n := range q.ch {
+		for _, notifier := range q.notifiers {
+			if err := notifier.Notify(n); err != nil {
+				slog.Warn("notification failed", "user", n.User,
+					"title", n.Title, "revision", n.Revision, "err", err)
+			}
+		}
+	}
+}
+
+// The method enqueue adds a notification to the queue, without blocking.
+func (q *notifyQueue) enqueue(n Notification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.ch <- n:
+	default:
+		slog.Warn("notification dropped: the queue is full", "user", n.User,
+			"title", n.Title)
+	}
+}
+
+// The method stop closes the queue, and waits until the notifications in it
+// are delivered; the method Close of the wiki calls it.
+func (q *notifyQueue) stop() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+// The method notifyWatchers enqueues a notification of a new revision for
+// each user watching the page, except its author and those who cannot read it
+// anymore.
+func (wk *Wiki) notifyWatchers(p *Page) {
+	for _, name := range wk.watches.watchers(p.Title) {
+		u := wk.users.get(name)
+		if u == nil || name == p.Author || wk.access(u, p.Title) < AccessRead {
+			continue
+		}
+		link := wk.pageURL("view", p.Title)
+		if wk.baseURL != "" {
+			link = strings.TrimSuffix(wk.baseURL, "/") + link
+		}
+		wk.notifications.enqueue(Notification{User: name, Email: u.Email,
+			Title: p.Title, Revision: p.Number, Author: p.Author,
+			Comment: p.Comment, Time: p.Time, URL: link})
+	}
+}
+
+// An smtpNotifier mails the notifications to the users who have an address,
+// through the SMTP server at addr ("host:port"). auth may be nil if the server
+// does not require authentication; net/smtp sends the credentials only over
+// TLS, or to a server on localhost.
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// The function NewSMTPNotifier returns a Notifier mailing the notifications
+// from the address from, through the SMTP server at addr; auth may be nil.
+func NewSMTPNotifier(addr, from string, auth smtp.Auth) Notifier {
+	return &smtpNotifier{addr: addr, from: from, auth: auth}
+}
+
+func (s *smtpNotifier) Notify(n Notification) error {
+	if n.Email == "" {
+		return nil
+	}
+	// The titles may hold any letter, so the subject is encoded as RFC 2047
+	// says; the body is sent as UTF-8 text. The DATA writer of net/smtp
+	// turns the line endings into CRLF.
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\n", n.Email)
+	fmt.Fprintf(&msg, "Subject: %s\n", mime.QEncoding.Encode("utf-8",
+		n.Title+" was changed"))
+	fmt.Fprintf(&msg, "Date: %s\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
+	fmt.Fprintf(&msg, "%s was changed by %s (revision %d).\n", n.Title,
+		n.Author, n.Revision)
+	if n.Comment != "" {
+		fmt.Fprintf(&msg, "\n%s\n", n.Comment)
+	}
+	fmt.Fprintf(&msg, "\n%s\n\nYou receive this message because you watch "+
+		"%s.\n", n.URL, n.Title)
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{n.Email},
+		msg.Bytes())
+}
+
+// A webhookNotifier posts each notification as a JSON object to a URL. A
+// failed delivery (a network error, a 5xx status or 429 Too Many Requests) is
+// tried again up to retries times, waiting backoff, then twice as long each
+// time; the other statuses are final.
+type webhookNotifier struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// The function NewWebhookNotifier returns a Notifier posting the
+// notifications to url; a failed delivery is tried again four times, after
+// one second, then two, four and eight.
+func NewWebhookNotifier(url string) Notifier {
+	return &webhookNotifier{url: url,
+		client: &http.Client{Timeout: 10 * time.Second}, retries: 4,
+		backoff: time.Second}
+}
+
+func (h *webhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	wait := h.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil || !retry || attempt == h.retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// The method post makes one attempt at delivering body, and tells whether a
+// failure is worth another attempt.
+func (h *webhookNotifier) post(body []byte) (bool, error) {
+	resp, err := h.client.Post(h.url, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	// The body is read to the end so the connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("web hook: %s", resp.Status)
+	}
+	return false, fmt.Errorf("web hook: %s", resp.Status)
+}
@@ -0,0 +1,226 @@
+package gowiki
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A recorder is a Notifier keeping the notifications it receives.
+type recorder struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recorder) Notify(n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	s := newMemStore()
+	s.Save(&Page{Title: "FrontPage", Body: []byte("Welcome")})
+	rec := &recorder{}
+	wk := newTestWiki(t, Options{Store: s, Notifiers: []Notifier{rec}})
+	wk.users = &UserDB{users: map[string]*User{
+		"alice": {Name: "alice", Email: "alice@example.com"},
+		"bob":   {Name: "bob"},
+	}}
+	wk.watches = &WatchDB{lists: make(map[string][]string)}
+	token, _ := wk.sessions.create("alice", wk.clock())
+	defer wk.sessions.remove(token)
+
+	form := url.Values{"action": {"watch"}, "csrf": {"t0k3n"}}
+	r := httptest.NewRequest("POST", "/watch/FrontPage",
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "t0k3n"})
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	w := httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	if w.Code != http.StatusFound ||
+		!wk.watches.watching("alice", "FrontPage") {
+		t.Fatalf("watch: status %d, watchlist %q", w.Code,
+			wk.watches.list("alice"))
+	}
+	if w := do(wk, "POST", "/watch/FrontPage",
+		url.Values{"action": {"watch"}}); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous watch: status %d", w.Code)
+	}
+
+	// The saves by alice are not notified to alice.
+	for _, author := range []string{"alice", "bob"} {
+		p := &Page{Title: "FrontPage", Body: []byte("Hello from " + author),
+			Revision: Revision{Author: author, Comment: "greeting"}}
+		if err := wk.save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wk.notifications.stop()
+	want := []Notification{{User: "alice", Email: "alice@example.com",
+		Title: "FrontPage", Revision: 3, Author: "bob", Comment: "greeting",
+		URL: "/view/FrontPage"}}
+	for i := range rec.sent {
+		rec.sent[i].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(rec.sent, want) {
+		t.Errorf("sent %+v, want %+v", rec.sent, want)
+	}
+
+	if err := wk.watches.rename("FrontPage", "HomePage"); err != nil {
+		t.Fatal(err)
+	}
+	if got := wk.watches.watchers("HomePage"); !reflect.DeepEqual(got,
+		[]string{"alice"}) {
+		t.Errorf("watchers after rename: %q", got)
+	}
+}
+
+// The function fakeSMTP serves a single SMTP session on a local port, and
+// sends the recipients and the message it receives on the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mails := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		in := bufio.NewReader(c)
+		reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+		var mail strings.Builder
+		reply("220 localhost ESMTP")
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "RCPT"):
+				mail.WriteString(strings.TrimSpace(line) + "\n")
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := in.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					mail.WriteString(line)
+				}
+				reply("250 OK")
+				mails <- mail.String()
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, mails := fakeSMTP(t)
+	n := &smtpNotifier{addr: addr, from: "wiki@example.com"}
+	err := n.Notify(Notification{User: "alice", Email: "alice@example.com",
+		Title: "Café", Revision: 2, Author: "bob", Comment: "Menu",
+		Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		URL:  "https://wiki.example.com/view/Café"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	for _, want := range []string{
+		"RCPT TO:<alice@example.com>\n",
+		"To: alice@example.com\r\n",
+		"Subject: =?utf-8?q?Caf=C3=A9_was_changed?=\r\n",
+		"Date: Wed, 01 May 2024 12:00:00 +0000\r\n",
+		"\r\nCafé was changed by bob (revision 2).\r\n\r\nMenu\r\n",
+		"https://wiki.example.com/view/Café\r\n",
+	} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail without %q:\n%s", want, mail)
+		}
+	}
+	// Without an address, there is nothing to send.
+	if err := n.Notify(Notification{User: "bob"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	// The server answers with the statuses of replies in turn, then 200 OK.
+	var mu sync.Mutex
+	var replies []int
+	var attempts int
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		status := http.StatusOK
+		if len(replies) > 0 {
+			status, replies = replies[0], replies[1:]
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	n := &webhookNotifier{url: srv.URL, client: srv.Client(), retries: 3,
+		backoff: time.Millisecond}
+	sent := Notification{User: "alice", Email: "alice@example.com",
+		Title: "FrontPage", Revision: 2, Author: "bob",
+		Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	for _, test := range []struct {
+		replies  []int
+		attempts int
+		ok       bool
+	}{
+		{nil, 1, true},
+		{[]int{503, 429}, 3, true},
+		{[]int{500, 502, 503, 504}, 4, false},
+		// A client error is final.
+		{[]int{400}, 1, false},
+	} {
+		replies, attempts, got = test.replies, 0, Notification{}
+		err := n.Notify(sent)
+		if (err == nil) != test.ok || attempts != test.attempts {
+			t.Errorf("replies %v: %v after %d attempts, want ok %v after %d",
+				test.replies, err, attempts, test.ok, test.attempts)
+		}
+	}
+	// The address of the user is not posted.
+	want := sent
+	want.Email = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("posted %+v, want %+v", got, want)
+	}
+	srv.Close()
+	if err := n.Notify(sent); err == nil {
+		t.Error("delivered to a closed server")
+	}
+}
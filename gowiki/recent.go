@@ -0,0 +1,347 @@
+package gowiki
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A change is a save of a page: the title, the revision it created, and the
+// first lines it added or removed, with whether there were more (see
+// excerptOf). The excerpt is computed once, when the change is recorded, so
+// listing the changes does not compare any revisions.
+type change struct {
+	Title string
+	Revision
+	Excerpt []diffLine
+	More    bool
+}
+
+// A recentLog holds the latest changes across the wiki, newest first, so that
+// the list of recent changes does not require reading the history of every
+// page. Like the links graph, it is built from the page store at startup and
+// updated by each save.
+type recentLog struct {
+	mu      sync.RWMutex
+	size    int
+	changes []change
+}
+
+// The number of changes kept by the recent log of the wiki.
+const recentSize = 200
+
+func newRecentLog(size int) *recentLog {
+	return &recentLog{size: size}
+}
+
+// The function buildRecentLog reads the history of every page in the store and
+// keeps the latest size changes.
+func buildRecentLog(s PageStore, size int) (*recentLog, error) {
+	l := newRecentLog(size)
+	titles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		history, err := s.History(title)
+		if err != nil {
+			return nil, err
+		}
+		for _, rev := range history {
+			l.changes = append(l.changes, change{Title: title, Revision: rev})
+		}
+	}
+	// SliceStable keeps the order of the history of each page (newest first)
+	// for the revisions saved at the same time.
+	sort.SliceStable(l.changes, func(i, j int) bool {
+		return l.changes[i].Time.After(l.changes[j].Time)
+	})
+	if len(l.changes) > size {
+		l.changes = l.changes[:size]
+	}
+	for i, c := range l.changes {
+		l.changes[i].Excerpt, l.changes[i].More, err = diffExcerpt(s, c.Title,
+			c.Number)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// The method add records a new revision of a page, given the body of the
+// revision it replaced (nil for a new page).
+func (l *recentLog) add(title string, rev Revision, old, body []byte) {
+	c := change{Title: title, Revision: rev}
+	c.Excerpt, c.More = excerptOf(old, body)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// The new change is prepended, and the oldest one dropped if the log is
+	// full; append reuses the underlying array when it has room.
+	l.changes = append(l.changes, change{})
+	copy(l.changes[1:], l.changes)
+	l.changes[0] = c
+	if len(l.changes) > l.size {
+		l.changes = l.changes[:l.size]
+	}
+}
+
+// The method remove forgets the changes of a page that has been deleted, as
+// their revisions do not exist anymore.
+func (l *recentLog) remove(title string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := l.changes[:0]
+	for _, c := range l.changes {
+		if c.Title != title {
+			kept = append(kept, c)
+		}
+	}
+	l.changes = kept
+}
+
+// The method rename moves the changes of a page to its new title, where its
+// history has been moved.
+func (l *recentLog) rename(from, to string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.changes {
+		if l.changes[i].Title == from {
+			l.changes[i].Title = to
+		}
+	}
+}
+
+// The method list returns up to n of the latest changes to pages that the
+// function readable accepts.
+func (l *recentLog) list(n int, readable func(title string) bool) []change {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var changes []change
+	for _, c := range l.changes {
+		if len(changes) == n {
+			break
+		}
+		if readable(c.Title) {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// The largest number of changed lines shown for each change.
+const excerptLines = 8
+
+// Past this number of lines in either revision, a change is shown without
+// its excerpt, so that no large page is ever compared for it.
+const excerptMaxLines = 2000
+
+// The function excerptOf returns the first lines added or removed by a
+// change of the body old to body, and whether there were more.
+func excerptOf(old, body []byte) ([]diffLine, bool) {
+	a, b := splitLines(string(old)), splitLines(string(body))
+	if len(a) > excerptMaxLines || len(b) > excerptMaxLines {
+		return nil, true
+	}
+	var lines []diffLine
+	for _, l := range diffLines(a, b) {
+		if l.Op == ' ' {
+			continue
+		}
+		if len(lines) == excerptLines {
+			return lines, true
+		}
+		lines = append(lines, l)
+	}
+	return lines, false
+}
+
+// The function diffExcerpt returns the excerpt of a revision of a page in the
+// store s, compared with the revision before it.
+func diffExcerpt(s PageStore, title string, number int) ([]diffLine, bool,
+	error) {
+	p, err := s.LoadRevision(title, number)
+	if err != nil {
+		return nil, false, err
+	}
+	var old []byte
+	if number > 1 {
+		prev, err := s.LoadRevision(title, number-1)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, false, err
+		}
+		if err == nil {
+			old = prev.Body
+		}
+	}
+	lines, more := excerptOf(old, p.Body)
+	return lines, more, nil
+}
+
+// The number of changes listed by /recent and by the feeds.
+const (
+	recentPageSize = 50
+	feedSize       = 30
+)
+
+// The method recentHandler lists the latest changes across the wiki; it
+// handles the URL "/recent".
+func (wk *Wiki) recentHandler(w http.ResponseWriter, r *http.Request) {
+	wk.renderTemplate(w, "recent", struct {
+		Changes []change
+	}{wk.recent.list(recentPageSize, wk.canRead(r))})
+}
+
+// The method absoluteURL returns the absolute URL of a path of the wiki, which
+// starts with the prefix it is mounted under.
+func (wk *Wiki) absoluteURL(r *http.Request, path string) string {
+	if wk.baseURL != "" {
+		return strings.TrimSuffix(wk.baseURL, "/") + path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// The types below describe an Atom feed (RFC 4287). The encoding/xml package
+// maps the fields to XML elements and attributes as the struct tags say: the
+// name in the tag of the field XMLName, with its namespace, is the name of the
+// root element.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Link    atomLink   `xml:"link"`
+	Summary string     `xml:"summary,omitempty"`
+	Content atomText   `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// The text of an element with the type "html" is HTML escaped once more, which
+// encoding/xml does for the character data.
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// The function excerptHTML renders a diff excerpt for the content of a feed
+// entry.
+func excerptHTML(v change) string {
+	var b strings.Builder
+	b.WriteString("<pre>")
+	for _, l := range v.Excerpt {
+		tag := "ins"
+		if l.Op == '-' {
+			tag = "del"
+		}
+		fmt.Fprintf(&b, "<%s>%c%s</%s>\n", tag, l.Op, html.EscapeString(l.Text),
+			tag)
+	}
+	if v.More {
+		b.WriteString("...\n")
+	}
+	b.WriteString("</pre>")
+	return b.String()
+}
+
+// The method feedHandler serves the latest changes as an Atom feed; it
+// handles the URL "/feed.atom". With the query parameter "page", the feed
+// lists the latest revisions of that page only.
+func (wk *Wiki) feedHandler(w http.ResponseWriter, r *http.Request) {
+	feed := atomFeed{
+		Title: "Recent changes",
+		ID:    wk.absoluteURL(r, wk.basePath+"/feed.atom"),
+		Links: []atomLink{{Href: wk.absoluteURL(r, wk.basePath+"/recent")},
+			{Rel: "self",
+				Href: wk.absoluteURL(r, wk.basePath+r.URL.RequestURI())}},
+	}
+	var changes []change
+	if r.FormValue("page") == "" {
+		changes = wk.recent.list(feedSize, wk.canRead(r))
+	} else {
+		title, ok := canonicalTitle(r.FormValue("page"))
+		if !ok {
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		}
+		if !wk.allow(w, r, title, AccessRead) {
+			return
+		}
+		history, err := wk.store.History(title)
+		if errors.Is(err, ErrNotFound) {
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, rev := range history[:min(len(history), feedSize)] {
+			c := change{Title: title, Revision: rev}
+			c.Excerpt, c.More, err = diffExcerpt(wk.store, title, rev.Number)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				wk.httpError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, c)
+		}
+		feed.Title = "Changes to " + title
+		feed.ID = wk.absoluteURL(r, wk.pageURL("history", title))
+		feed.Links[0].Href = feed.ID
+	}
+	// The feed was last updated by its newest entry.
+	feed.Updated = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	if len(changes) > 0 {
+		feed.Updated = changes[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, v := range changes {
+		u := wk.absoluteURL(r, fmt.Sprintf("%s?rev=%d",
+			wk.pageURL("view", v.Title), v.Number))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   fmt.Sprintf("%s (revision %d)", v.Title, v.Number),
+			ID:      u,
+			Updated: v.Time.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{v.Author},
+			Link: atomLink{Href: wk.absoluteURL(r, fmt.Sprintf("%s?to=%d",
+				wk.pageURL("diff", v.Title), v.Number))},
+			Summary: v.Comment,
+			Content: atomText{"html", excerptHTML(v)},
+		})
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	// MarshalIndent would build the whole document in memory; an Encoder
+	// writes it directly to the response.
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(feed); err != nil {
+		log.Printf("feed: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package gowiki
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecentChanges(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	wk.recent = newRecentLog(3)
+	for _, p := range []*Page{
+		{Title: "Runbook", Body: []byte("one\n")},
+		{Title: "FrontPage", Body: []byte("hello\n")},
+		{Title: "Runbook", Body: []byte("one\ntwo\n"),
+			Revision: Revision{Author: "alice", Comment: "add step"}},
+		{Title: "Old", Body: []byte("old\n")},
+	} {
+		if err := wk.save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest("GET", "/recent", nil)
+	titles := func() []string {
+		var titles []string
+		for _, c := range wk.recent.list(10, wk.canRead(r)) {
+			titles = append(titles, c.Title)
+		}
+		return titles
+	}
+	// The log keeps the latest 3 changes, newest first.
+	if got := titles(); len(got) != 3 || got[0] != "Old" || got[2] != "FrontPage" {
+		t.Errorf("recent changes %v", got)
+	}
+	// The excerpt is recorded with the change.
+	if c := wk.recent.list(10, wk.canRead(r))[1]; !reflect.DeepEqual(c.Excerpt,
+		[]diffLine{{Op: '+', Text: "two"}}) || c.More {
+		t.Errorf("excerpt of %s %d: %v, %v", c.Title, c.Number, c.Excerpt,
+			c.More)
+	}
+	if err := wk.deletePage("Old", -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(); len(got) != 2 || got[0] != "Runbook" {
+		t.Errorf("recent changes after delete %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	wk.feedHandler(w,
+		httptest.NewRequest("GET", "/feed.atom?page=Runbook", nil))
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("%d entries in the feed of Runbook, want 2", len(feed.Entries))
+	}
+	e := feed.Entries[0]
+	if e.Author.Name != "alice" || e.Summary != "add step" ||
+		e.Content.Text != "<pre><ins>+two</ins>\n</pre>" {
+		t.Errorf("feed entry %+v", e)
+	}
+}
+
+func TestExcerptOf(t *testing.T) {
+	lines, more := excerptOf([]byte("a\nb\n"), []byte("a\nc\n"))
+	if want := []diffLine{{Op: '-', Text: "b"}, {Op: '+', Text: "c"}}; !reflect.DeepEqual(lines, want) || more {
+		t.Errorf("excerptOf() = %v, %v, want %v, false", lines, more, want)
+	}
+	lines, more = excerptOf(nil, []byte(strings.Repeat("line\n", 20)))
+	if len(lines) != excerptLines || !more {
+		t.Errorf("excerptOf() of a long page = %d lines, %v", len(lines), more)
+	}
+	// A large page is not compared at all.
+	big := []byte(strings.Repeat("line\n", excerptMaxLines+1))
+	if lines, more := excerptOf(nil, big); lines != nil || !more {
+		t.Errorf("excerptOf() of a large page = %v, %v", lines, more)
+	}
+}
@@ -0,0 +1,482 @@
+package gowiki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A redirect stub is a page whose body starts with "#REDIRECT [[Title]]": the
+// view page sends its readers to Title, unless the query has "redirect=no".
+// Renaming a page leaves a stub under the old title, so the old URLs keep
+// working.
+var redirectLine = regexp.MustCompile(`^#REDIRECT\s*\[\[([^\]]+)\]\]`)
+
+// The function redirectTarget returns the title a redirect stub leads to.
+func redirectTarget(body []byte) (string, bool) {
+	m := redirectLine.FindSubmatch(body)
+	if m == nil {
+		return "", false
+	}
+	title := wikiTitle(string(m[1]))
+	return title, title != ""
+}
+
+// The function rewriteLinks returns the body with the links to the page from
+// renamed to to. The links are found as the renderer finds them, so a link is
+// rewritten if and only if the page shows it as one: the front matter, code
+// blocks and code spans are left alone.
+func rewriteLinks(body, from, to string) string {
+	// A WikiWord link can be kept as such only if the new title is a WikiWord
+	// too.
+	rw := &linkRewriter{from: from, to: to, word: "[[" + to + "]]"}
+	if wikiWord.FindString(to) == to {
+		rw.word = to
+	}
+	_, rest := splitFrontMatter([]byte(body))
+	front := len(body) - len(rest)
+	rw.b.WriteString(body[:front])
+	lines := strings.SplitAfter(body[front:], "\n")
+	rw.blocks(lines, lines)
+	return rw.b.String()
+}
+
+// A linkRewriter writes a body with the links to the page from renamed to to.
+// Its methods follow those of the markdownRenderer, but write the text as it
+// is rather than HTML.
+type linkRewriter struct {
+	from, to string
+	// The field word is what a WikiWord link to from becomes.
+	word string
+	b    strings.Builder
+}
+
+// The method blocks rewrites a sequence of lines, split into blocks as the
+// renderer splits them. Each line is given as it is in the body (raw) and as
+// the renderer reads it (text), which is raw without the ">" of the block
+// quotes it is in.
+func (rw *linkRewriter) blocks(raw, text []string) {
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			rw.inline(raw, text, start, 0, end)
+			start = -1
+		}
+	}
+	for i := 0; i < len(raw); i++ {
+		line := lineText(text[i])
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush(i)
+			rw.b.WriteString(raw[i])
+		case strings.HasPrefix(trimmed, "```"):
+			flush(i)
+			rw.b.WriteString(raw[i])
+			for i++; i < len(raw); i++ {
+				rw.b.WriteString(raw[i])
+				if strings.HasPrefix(strings.TrimSpace(text[i]), "```") {
+					break
+				}
+			}
+		case headingLine.MatchString(trimmed):
+			flush(i)
+			m := headingLine.FindStringSubmatchIndex(trimmed)
+			rw.inline(raw, text, i, strings.Index(text[i], trimmed)+m[4], i+1)
+		case ruleLine.MatchString(line):
+			flush(i)
+			rw.b.WriteString(raw[i])
+		case strings.HasPrefix(trimmed, ">"):
+			flush(i)
+			var quote []string
+			j := i
+			for ; j < len(raw); j++ {
+				t := text[j]
+				if !strings.HasPrefix(strings.TrimSpace(t), ">") {
+					break
+				}
+				t = t[strings.IndexByte(t, '>')+1:]
+				quote = append(quote, strings.TrimPrefix(t, " "))
+			}
+			rw.blocks(raw[i:j], quote)
+			i = j - 1
+		case bulletItem.MatchString(line) && start < 0:
+			i = rw.list(raw, text, i, bulletItem)
+		case orderedItem.MatchString(line) && start < 0:
+			i = rw.list(raw, text, i, orderedItem)
+		case start < 0:
+			start = i
+		}
+	}
+	flush(len(raw))
+}
+
+// The function lineText returns a line of a body without its line ending, as
+// splitLines returns it.
+func lineText(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+// The method list rewrites the list starting at line start, whose items match
+// item, and returns the index of its last line.
+func (rw *linkRewriter) list(raw, text []string, start int,
+	item *regexp.Regexp) int {
+	i := start
+	for i < len(raw) {
+		m := item.FindStringSubmatchIndex(lineText(text[i]))
+		if m == nil {
+			break
+		}
+		end := i + 1
+		for end < len(raw) && strings.HasPrefix(text[end], " ") &&
+			strings.TrimSpace(text[end]) != "" &&
+			!item.MatchString(lineText(text[end])) {
+			end++
+		}
+		rw.inline(raw, text, i, m[2], end)
+		i = end
+	}
+	return i - 1
+}
+
+// The method inline rewrites the text of a block: the lines from start to end
+// (excluded), whose text starts at position from in the first one. What
+// precedes it is written as it is, and so are the ">" and the indentation of
+// the other lines, which take no part in any span.
+func (rw *linkRewriter) inline(raw, text []string, start, from, end int) {
+	first := text[start][from:]
+	rw.b.WriteString(raw[start][:len(raw[start])-len(first)])
+	rw.spans(first + strings.Join(raw[start+1:end], ""))
+}
+
+// The method spans rewrites the links in a text, as the method inline of the
+// renderer renders it.
+func (rw *linkRewriter) spans(s string) {
+	t := &inlineText{s: s}
+	for i := 0; i < len(s); {
+		if n := rw.span(t, i, i == 0 || !isWordByte(s[i-1])); n > 0 {
+			i += n
+			continue
+		}
+		rw.b.WriteByte(s[i])
+		i++
+	}
+}
+
+// The method span rewrites the span at position i of the text, if any, and
+// returns the number of bytes it consumed, as the method span of the renderer
+// renders it.
+func (rw *linkRewriter) span(t *inlineText, i int, atWord bool) int {
+	s := t.s[i:]
+	switch {
+	case s[0] == '`':
+		if end := t.index("`", i+1); end >= 0 {
+			rw.b.WriteString(s[:end+2])
+			return end + 2
+		}
+	case strings.HasPrefix(s, "**"):
+		if end := t.index("**", i+2); end > 0 {
+			rw.b.WriteString("**")
+			rw.spans(s[2 : end+2])
+			rw.b.WriteString("**")
+			return end + 4
+		}
+	case s[0] == '*':
+		if end := t.index("*", i+1); end > 0 && s[1] != ' ' {
+			rw.b.WriteString("*")
+			rw.spans(s[1 : end+1])
+			rw.b.WriteString("*")
+			return end + 2
+		}
+	case strings.HasPrefix(s, "![["):
+		end := t.index("]]", i)
+		if end <= 3 {
+			break
+		}
+		// The reference is valid as for the method attachment.
+		ref := s[3:end]
+		title, name := "", ref
+		j := strings.LastIndexByte(ref, '/')
+		if j >= 0 {
+			title, name = wikiTitle(ref[:j]), ref[j+1:]
+			if title == "" {
+				break
+			}
+		}
+		if !validAttachmentName(name) {
+			break
+		}
+		if title == rw.from {
+			rw.b.WriteString("![[" + rw.to + ref[j:] + "]]")
+		} else {
+			rw.b.WriteString(s[:end+2])
+		}
+		return end + 2
+	case strings.HasPrefix(s, "!["):
+		if _, _, n := t.link(i + 1); n > 0 {
+			rw.b.WriteString(s[:n+1])
+			return n + 1
+		}
+	case strings.HasPrefix(s, "[["):
+		if end := t.index("]]", i); end > 2 {
+			if title := wikiTitle(s[2:end]); title == rw.from {
+				rw.b.WriteString("[[" + rw.to + "]]")
+				return end + 2
+			} else if title != "" {
+				rw.b.WriteString(s[:end+2])
+				return end + 2
+			}
+		}
+	case s[0] == '[':
+		if text, _, n := t.link(i); n > 0 {
+			rw.b.WriteString("[")
+			rw.spans(text)
+			rw.b.WriteString(s[1+len(text) : n])
+			return n
+		}
+		if end := t.index("]", i); end > 1 {
+			if title := wikiTitle(s[1:end]); title == s[1:end] {
+				if title == rw.from {
+					title = rw.to
+				}
+				rw.b.WriteString("[" + title + "]")
+				return end + 1
+			}
+		}
+	case s[0] == '!' && atWord:
+		// An escaped WikiWord is not a link: it is copied as it is.
+		if w := wikiWord.FindString(s[1:]); w != "" && wordEnds(s[1+len(w):]) {
+			rw.b.WriteString(s[:len(w)+1])
+			return len(w) + 1
+		}
+	case atWord:
+		if w := wikiWord.FindString(s); w != "" && wordEnds(s[len(w):]) {
+			if w == rw.from {
+				rw.b.WriteString(rw.word)
+			} else {
+				rw.b.WriteString(w)
+			}
+			return len(w)
+		}
+	}
+	return 0
+}
+
+// The method renamePage moves a page to a new title, with its history and
+// attachments, for the editor e. If rewrite is true, the links to the page in
+// the other pages are changed to the new title, except in the pages e cannot
+// edit or whose save is refused, whose titles are returned; if stub is true, a
+// redirect stub is left under the old title. The rename counts as one save for
+// the rate limits, and the pages it saves are screened as the others (see
+// abuse.go).
+func (wk *Wiki) renamePage(from, to string, e editor, rewrite,
+	stub bool) ([]string, error) {
+	if err := wk.limitSaves(e); err != nil {
+		return nil, err
+	}
+	// Both titles are locked, always in the same order, so two renames in
+	// opposite directions cannot wait for each other forever.
+	first, second := from, to
+	if second < first {
+		first, second = second, first
+	}
+	unlock1 := wk.lockTitle(first)
+	unlock2 := wk.lockTitle(second)
+	err := wk.removeStub(to, from)
+	if err == nil {
+		err = wk.store.Rename(from, to)
+	}
+	if err == nil {
+		var p *Page
+		if p, err = wk.loadPage(to); err == nil {
+			wk.links.remove(from)
+			wk.index.remove(from)
+			wk.recent.rename(from, to)
+			wk.tags.remove(from)
+			wk.links.update(to, wk.pageLinks(p.Body))
+			wk.index.update(p)
+			wk.tags.update(to, p.Meta["tags"])
+			wk.rendered.invalidate()
+			wk.events.publish(pageEvent{Type: "rename", Title: from, To: to})
+			err = wk.watches.rename(from, to)
+		}
+	}
+	if err == nil && stub {
+		p := &Page{Title: from, Body: []byte("#REDIRECT [[" + to + "]]\n"),
+			Revision: Revision{
+				Author:  e.author(),
+				Comment: fmt.Sprintf("Renamed to %s", to),
+			}}
+		if err = wk.screenSave(e, p, nil, 0); err == nil {
+			err = wk.commit(p)
+		}
+		// A stub refused or held for moderation is not an error: the page is
+		// renamed all the same.
+		var refused *refusedError
+		var held *heldError
+		if errors.As(err, &refused) || errors.As(err, &held) {
+			err = nil
+		}
+	}
+	unlock2()
+	unlock1()
+	if err != nil || !rewrite {
+		return nil, err
+	}
+	// The pages linking to the old title are rewritten one by one, each with
+	// its own lock. Renaming a page does not let its user change the pages
+	// they could not edit otherwise, or save a body refused otherwise: those
+	// are left alone.
+	var skipped []string
+	comment := fmt.Sprintf("Rename links from %s to %s", from, to)
+	for _, source := range wk.links.backlinks(from) {
+		if source == from {
+			continue
+		}
+		if wk.access(e.user, source) < AccessEdit {
+			skipped = append(skipped, source)
+			continue
+		}
+		p, err := wk.loadPage(source)
+		if err != nil {
+			return skipped, err
+		}
+		body := rewriteLinks(string(p.Body), from, to)
+		if body == string(p.Body) {
+			continue
+		}
+		err = wk.saveScreened(&Page{Title: source, Body: []byte(body),
+			Revision: Revision{Author: e.author(), Comment: comment}}, e,
+			p.Number)
+		var refused *refusedError
+		var held *heldError
+		switch {
+		case errors.As(err, &refused):
+			skipped = append(skipped, source)
+		case errors.As(err, &held):
+			// The rewrite is written once a moderator approves it.
+		case err != nil:
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// The method removeStub deletes the page title if it is just the redirect
+// stub to target that renaming target left, so the page can be renamed back;
+// the caller must hold the lock on the title. A page with more than one
+// revision, or with more than the redirect, is kept.
+func (wk *Wiki) removeStub(title, target string) error {
+	p, err := wk.store.Load(title)
+	if err != nil {
+		// A missing page is not in the way, and the rename reports the
+		// other errors.
+		return nil
+	}
+	m := redirectLine.Find(p.Body)
+	if to, _ := redirectTarget(p.Body); to != target || p.Number != 1 ||
+		len(bytes.TrimSpace(p.Body[len(m):])) > 0 {
+		return nil
+	}
+	return wk.remove(title)
+}
+
+// The method renameHandler shows the form to rename a page, and renames it
+// when the form is submitted; it handles URLs prefixed with "/rename/".
+func (wk *Wiki) renameHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	data := struct {
+		Title, To, Error, CSRF string
+		Backlinks, Skipped     []string
+	}{Title: title, Backlinks: wk.readable(r, wk.links.backlinks(title)),
+		CSRF: wk.csrfToken(w, r)}
+	if r.Method != http.MethodPost {
+		wk.renderTemplate(w, "rename", data)
+		return
+	}
+	if !wk.checkCSRF(w, r) {
+		return
+	}
+	to, ok := canonicalTitle(r.FormValue("to"))
+	data.To = r.FormValue("to")
+	switch {
+	case !ok:
+		data.Error = "The new title is not valid."
+	case to == title:
+		data.Error = "The new title is the same as the old one."
+	case wk.access(wk.currentUser(r), to) < AccessEdit:
+		data.Error = "You are not allowed to edit " + to + "."
+	}
+	if data.Error == "" {
+		skipped, err := wk.renamePage(title, to, wk.requestEditor(r),
+			r.FormValue("rewrite") != "", r.FormValue("stub") != "")
+		switch {
+		case wk.refuseSave(w, err):
+			return
+		case errors.Is(err, ErrNotFound):
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrExists):
+			data.Error = "A page called " + to + " exists already."
+		case err != nil:
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		case len(skipped) > 0:
+			// The page is renamed, but the user is told which links are
+			// still to the old title.
+			data.Title, data.Skipped = to, wk.readable(r, skipped)
+			wk.renderTemplate(w, "renamed", data)
+			return
+		default:
+			http.Redirect(w, r, wk.pageURL("view", to), http.StatusFound)
+			return
+		}
+	}
+	wk.renderStatus(w, http.StatusBadRequest, "rename", data)
+}
+
+// The method deleteHandler asks for confirmation, and deletes a page with
+// its history and attachments when the form is submitted; it handles URLs
+// prefixed with "/delete/".
+func (wk *Wiki) deleteHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	if r.Method != http.MethodPost {
+		wk.renderTemplate(w, "delete", struct {
+			Title     string
+			Backlinks []string
+			CSRF      string
+		}{title, wk.readable(r, wk.links.backlinks(title)), wk.csrfToken(w, r)})
+		return
+	}
+	if !wk.checkCSRF(w, r) {
+		return
+	}
+	err := wk.deletePage(title, -1)
+	if errors.Is(err, ErrNotFound) {
+		wk.httpError(w, "There is no such page.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, wk.basePath+"/index/", http.StatusFound)
+}
+
+// The method followRedirect sends the client from a redirect stub to its
+// target, and returns true; it returns false if the page is not a stub, or
+// the query asks not to follow it.
+func (wk *Wiki) followRedirect(w http.ResponseWriter, r *http.Request,
+	p *Page) bool {
+	target, ok := redirectTarget(p.Body)
+	if !ok || target == p.Title || r.FormValue("redirect") == "no" {
+		return false
+	}
+	http.Redirect(w, r, wk.pageURL("view", target)+"?from="+
+		url.QueryEscape(p.Title), http.StatusFound)
+	return true
+}
@@ -0,0 +1,108 @@
+package gowiki
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteLinks(t *testing.T) {
+	body := "See OldPage, [OldPage], [[Old Page]] and ![[OldPage/a.png]].\n" +
+		"Not OldPageToo, !OldPage or `OldPage`.\n```\nOldPage\n```\n"
+	want := "See [[Team/New]], [Team/New], [[Team/New]] and ![[Team/New/a.png]].\n" +
+		"Not OldPageToo, !OldPage or `OldPage`.\n```\nOldPage\n```\n"
+	if got := rewriteLinks(body, "OldPage", "Team/New"); got != want {
+		t.Fatalf("rewriteLinks() = %q, want %q", got, want)
+	}
+	if got := rewriteLinks("OldPage", "OldPage", "NewPage"); got != "NewPage" {
+		t.Fatalf("rewriteLinks() of a WikiWord = %q, want NewPage", got)
+	}
+
+	// The links rewritten are those the renderer shows, and only those.
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	for _, tc := range []struct{ body, want string }{
+		{"A `tick, then OldPage.", "A `tick, then NewPage."},
+		{"`code\nOldPage` and OldPage", "`code\nOldPage` and NewPage"},
+		{"[see](http://a.example/OldPage) [OldPage](/x)",
+			"[see](http://a.example/OldPage) [NewPage](/x)"},
+		{"**`x** OldPage`", "**`x** NewPage`"},
+		{"# About OldPage #\n> Quote `x\n> OldPage`\n>\n> OldPage\n",
+			"# About NewPage #\n> Quote `x\n> OldPage`\n>\n> NewPage\n"},
+		{"- `a\n- OldPage`\n  `b\n\nOldPage`", "- `a\n- NewPage`\n  `b\n\nNewPage`"},
+		{"---\ntitle: OldPage\n---\nOldPage\n",
+			"---\ntitle: OldPage\n---\nNewPage\n"},
+	} {
+		got := rewriteLinks(tc.body, "OldPage", "NewPage")
+		if got != tc.want {
+			t.Errorf("rewriteLinks(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+		want := wk.pageLinks([]byte(tc.body))
+		for i := range want {
+			if want[i] == "OldPage" {
+				want[i] = "NewPage"
+			}
+		}
+		if links := wk.pageLinks([]byte(got)); !reflect.DeepEqual(links, want) {
+			t.Errorf("links of %q = %q, want %q", got, links, want)
+		}
+	}
+}
+
+func TestRenamePage(t *testing.T) {
+	wk := newTestWiki(t, Options{Store: newMemStore()})
+	for _, p := range []*Page{
+		{Title: "OldPage", Body: []byte("content")},
+		{Title: "Other", Body: []byte("Link to OldPage.")},
+		{Title: "Locked/Page", Body: []byte("Link to OldPage.")},
+	} {
+		if err := wk.save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// alice may read the pages under Locked/, not edit them.
+	wk.acl = []ACLRule{{Prefix: "Locked/", Who: "*", Level: AccessRead}}
+	skipped, err := wk.renamePage("OldPage", "NewPage",
+		editor{user: &User{Name: "alice"}}, true, true)
+	if err != nil {
+		t.Fatalf("renamePage() error = %v", err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"Locked/Page"}) {
+		t.Errorf("renamePage() skipped %q, want [Locked/Page]", skipped)
+	}
+	if p, _ := wk.loadPage("Locked/Page"); p == nil || p.Number != 1 {
+		t.Errorf("Locked/Page rewritten by a user who cannot edit it: %v", p)
+	}
+	if p, err := wk.loadPage("Other"); err != nil ||
+		string(p.Body) != "Link to NewPage." {
+		t.Fatalf(`Other after renamePage() = %v, %v`, p, err)
+	}
+	if p, _ := wk.loadPage("OldPage"); p == nil {
+		t.Fatal("no redirect stub left under the old title")
+	} else if target, ok := redirectTarget(p.Body); !ok || target != "NewPage" {
+		t.Fatalf("redirect stub = %q, want a redirect to NewPage", p.Body)
+	}
+	got, want := wk.links.backlinks("NewPage"), []string{"OldPage", "Other"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf(`backlinks("NewPage") = %q, want %q`, got, want)
+	}
+
+	// The page can be renamed back over its redirect stub, not over another
+	// page.
+	_, err = wk.renamePage("NewPage", "Other", editor{}, false, false)
+	if !errors.Is(err, ErrExists) {
+		t.Fatalf("renamePage() over a page: error = %v, want ErrExists", err)
+	}
+	_, err = wk.renamePage("NewPage", "OldPage", editor{}, false, true)
+	if err != nil {
+		t.Fatalf("renamePage() back over its stub: error = %v", err)
+	}
+	if p, err := wk.loadPage("OldPage"); err != nil ||
+		string(p.Body) != "content" {
+		t.Fatalf("OldPage after renaming it back = %v, %v", p, err)
+	}
+	if p, _ := wk.loadPage("NewPage"); p == nil {
+		t.Fatal("no redirect stub left under NewPage")
+	} else if target, _ := redirectTarget(p.Body); target != "OldPage" {
+		t.Fatalf("redirect stub = %q, want a redirect to OldPage", p.Body)
+	}
+}
@@ -0,0 +1,262 @@
+package gowiki
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A searchIndex is an inverted index over the titles and bodies of the pages:
+// for each word, it records the pages containing it and how many times. It is
+// built from the page store at startup and updated by each save.
+type searchIndex struct {
+	mu sync.RWMutex
+	// postings maps a word to the number of its occurrences in each page.
+	postings map[string]map[string]int
+	// words maps a page to the words it contains, so its postings can be
+	// removed when it changes.
+	words map[string][]string
+	// bodies keeps the text of each page, to extract the snippets.
+	bodies map[string]string
+}
+
+// A word in the title of a page weighs as much as this many occurrences in
+// its body.
+const titleWeight = 5
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		words:    make(map[string][]string),
+		bodies:   make(map[string]string),
+	}
+}
+
+// The function buildSearchIndex reads every page in the store and indexes it.
+func buildSearchIndex(s PageStore) (*searchIndex, error) {
+	idx := newSearchIndex()
+	titles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		p, err := s.Load(title)
+		if err != nil {
+			return nil, err
+		}
+		idx.update(p)
+	}
+	return idx, nil
+}
+
+// The function tokenize splits a text into lower-case words: runs of letters
+// and digits.
+// FieldsFunc splits the string at each rune for which the function returns
+// true.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return words
+}
+
+// The function titleWords splits a title such as "WikiWord" in the words it
+// is made of, besides the title itself, so a search for "word" finds the page.
+func titleWords(title string) []string {
+	words := tokenize(title)
+	start := 0
+	for i, r := range title {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(title[start:i]))
+			start = i
+		}
+	}
+	if start > 0 {
+		words = append(words, strings.ToLower(title[start:]))
+	}
+	return words
+}
+
+// The method update indexes the current body of a page, replacing the previous
+// one.
+func (idx *searchIndex) update(p *Page) {
+	counts := make(map[string]int)
+	for _, w := range titleWords(p.Title) {
+		counts[w] += titleWeight
+	}
+	for _, w := range tokenize(string(p.Body)) {
+		counts[w]++
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(p.Title)
+	words := make([]string, 0, len(counts))
+	for w, n := range counts {
+		if idx.postings[w] == nil {
+			idx.postings[w] = make(map[string]int)
+		}
+		idx.postings[w][p.Title] = n
+		words = append(words, w)
+	}
+	idx.words[p.Title] = words
+	idx.bodies[p.Title] = string(p.Body)
+}
+
+// The method remove drops a deleted page from the index.
+func (idx *searchIndex) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+}
+
+// The method removeLocked must be called with the write lock held.
+func (idx *searchIndex) removeLocked(title string) {
+	for _, w := range idx.words[title] {
+		delete(idx.postings[w], title)
+		if len(idx.postings[w]) == 0 {
+			delete(idx.postings, w)
+		}
+	}
+	delete(idx.words, title)
+	delete(idx.bodies, title)
+}
+
+// A searchResult is a page matching a query, with an excerpt of its body
+// where the words of the query are highlighted.
+type searchResult struct {
+	Title   string
+	Score   int
+	Snippet template.HTML
+}
+
+// The method search returns the pages containing all the words of the query,
+// the ones where the words occur more often first.
+func (idx *searchIndex) search(query string) []searchResult {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	scores := make(map[string]int)
+	for title, n := range idx.postings[terms[0]] {
+		scores[title] = n
+	}
+	for _, term := range terms[1:] {
+		for title := range scores {
+			n, ok := idx.postings[term][title]
+			if !ok {
+				delete(scores, title)
+				continue
+			}
+			scores[title] += n
+		}
+	}
+	results := make([]searchResult, 0, len(scores))
+	for title, score := range scores {
+		results = append(results, searchResult{
+			Title:   title,
+			Score:   score,
+			Snippet: snippet(idx.bodies[title], terms, 160),
+		})
+	}
+	// The function Slice sorts the results with the given less
+	// function; pages with the same score are ordered by title.
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	return results
+}
+
+// The function snippet returns about width bytes of the body around the first
+// occurrence of one of the terms, escaped, with the occurrences of the terms
+// wrapped in <mark> elements.
+func snippet(body string, terms []string, width int) template.HTML {
+	// The positions are looked for in the lower-case body: for the few runes
+	// whose lower case has a different length, the highlight may be slightly
+	// off, but it is never out of range.
+	lower := strings.ToLower(body)
+	if len(lower) != len(body) {
+		lower = body
+	}
+	first := len(body)
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 && i < first {
+			first = i
+		}
+	}
+	if first == len(body) {
+		first = 0
+	}
+	start := max(first-width/4, 0)
+	end := min(start+width, len(body))
+	// Move the bounds to the nearest spaces, so no word is cut, or, in a text
+	// without spaces, to the nearest rune boundaries, so no rune is cut.
+	if start > 0 {
+		if i := strings.IndexByte(body[start:end], ' '); i >= 0 {
+			start += i + 1
+		}
+		for start < end && !utf8.RuneStart(body[start]) {
+			start++
+		}
+	}
+	if end < len(body) {
+		if i := strings.LastIndexByte(body[start:end], ' '); i > 0 {
+			end = start + i
+		}
+		for end > start && !utf8.RuneStart(body[end]) {
+			end--
+		}
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	for i := start; i < end; {
+		matched := ""
+		for _, term := range terms {
+			if strings.HasPrefix(lower[i:end], term) && len(term) > len(matched) {
+				matched = term
+			}
+		}
+		if matched != "" {
+			b.WriteString("<mark>" + html.EscapeString(body[i:i+len(matched)]) +
+				"</mark>")
+			i += len(matched)
+			continue
+		}
+		b.WriteString(html.EscapeString(body[i : i+1]))
+		i++
+	}
+	if end < len(body) {
+		b.WriteString(" …")
+	}
+	return template.HTML(b.String())
+}
+
+// The method searchHandler shows the pages matching the query parameter "q";
+// it handles the URL "/search".
+func (wk *Wiki) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	// Pages the user may not read are left out, as their snippets would
+	// disclose their content.
+	u := wk.currentUser(r)
+	var results []searchResult
+	for _, result := range wk.index.search(query) {
+		if wk.access(u, result.Title) >= AccessRead {
+			results = append(results, result)
+		}
+	}
+	wk.renderTemplate(w, "search", struct {
+		Query   string
+		Results []searchResult
+	}{query, results})
+}
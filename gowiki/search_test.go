@@ -0,0 +1,40 @@
+package gowiki
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchIndex(t *testing.T) {
+	idx := newSearchIndex()
+	idx.update(&Page{Title: "DeployRunbook", Body: []byte("Run the deploy script.")})
+	idx.update(&Page{Title: "Notes", Body: []byte("Deploy on Fridays? Never <ever> deploy.")})
+	results := idx.search("deploy")
+	if len(results) != 2 || results[0].Title != "DeployRunbook" {
+		t.Fatalf(`search("deploy") = %v, want DeployRunbook first`, results)
+	}
+	want := "<mark>Deploy</mark> on Fridays? Never &lt;ever&gt; <mark>deploy</mark>."
+	if got := string(results[1].Snippet); got != want {
+		t.Fatalf("snippet = %q, want %q", got, want)
+	}
+	if results := idx.search("deploy script"); len(results) != 1 {
+		t.Fatalf(`search("deploy script") = %v, want one page`, results)
+	}
+	idx.update(&Page{Title: "Notes", Body: []byte("Nothing here.")})
+	if results := idx.search("fridays"); len(results) != 0 {
+		t.Fatalf(`search("fridays") after update = %v, want none`, results)
+	}
+}
+
+// A snippet of a text without spaces is cut between runes, never inside one.
+func TestSnippetRunes(t *testing.T) {
+	body := strings.Repeat("é", 60) + "déploiement" + strings.Repeat("日本", 30)
+	for width := 20; width < 60; width++ {
+		got := string(snippet(body, []string{"déploiement"}, width))
+		if !utf8.ValidString(got) ||
+			!strings.Contains(got, "<mark>déploiement</mark>") {
+			t.Errorf("snippet of width %d = %q", width, got)
+		}
+	}
+}
@@ -0,0 +1,672 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A PageStore hides where the pages of the wiki are kept, so the handlers do
+// not need to know whether a page lives in a file, in memory or in a database.
+// Pages are never overwritten: each save adds a new immutable revision, and the
+// body of a page is the body of its latest revision.
+type PageStore interface {
+	// Load returns the latest revision of the page with the given title, or
+	// ErrNotFound if there is no such page.
+	Load(title string) (*Page, error)
+	// Save adds a new revision to the page, creating the page if needed; it
+	// sets the Number of the revision and, if it is zero, its Time.
+	Save(p *Page) error
+	// Delete removes the page with all its revisions; it returns ErrNotFound
+	// if there is no such page.
+	Delete(title string) error
+	// Rename moves a page with all its revisions and attachments to a new
+	// title; it returns ErrNotFound if there is no such page and ErrExists if
+	// a page with the new title exists already.
+	Rename(from, to string) error
+	// List returns the titles of all the pages, in alphabetical order.
+	List() ([]string, error)
+	// History returns the revisions of the page, the latest first.
+	History(title string) ([]Revision, error)
+	// LoadRevision returns the page as it was at the given revision.
+	LoadRevision(title string, number int) (*Page, error)
+	// SaveAttachment stores a file attached to a page, replacing the file with
+	// the same name if any; it sets the Size and, if it is zero, the Time of
+	// the attachment. Attachments are not versioned, and are deleted with the
+	// page.
+	SaveAttachment(title string, a *Attachment, data []byte) error
+	// LoadAttachment returns a file attached to a page, or ErrNotFound.
+	LoadAttachment(title, name string) (*Attachment, []byte, error)
+	// Attachments returns the files attached to a page, sorted by name.
+	Attachments(title string) ([]Attachment, error)
+}
+
+// A Revision describes one of the saves of a page. Revisions are numbered from
+// 1 in the order they are saved.
+type Revision struct {
+	Number  int
+	Author  string
+	Comment string
+	Time    time.Time
+}
+
+// An Attachment describes a file attached to a page.
+type Attachment struct {
+	Name        string
+	Size        int64
+	ContentType string
+	Time        time.Time
+}
+
+// ErrNotFound is returned by a PageStore when the requested page (or revision,
+// or attachment) does not exist.
+// The function New of the errors package returns an error whose message is
+// the given text; comparing against a sentinel value like this one (through
+// errors.Is) lets callers tell a missing page from a real failure.
+var ErrNotFound = errors.New("page not found")
+
+// ErrExists is returned by a PageStore when a page cannot be created because it
+// exists already.
+var ErrExists = errors.New("page exists already")
+
+// The function OpenStore returns the PageStore selected by kind: "fs", "mem",
+// "sqlite" or "git". dir is the data directory for the backends that keep
+// their data on disk; repo is the repository of the git store, by default
+// the directory "pages" of dir.
+func OpenStore(kind, dir, repo string) (PageStore, error) {
+	switch kind {
+	case "fs":
+		return newFSStore(dir)
+	case "mem":
+		return newMemStore(), nil
+	case "sqlite":
+		return newSQLiteStore(filepath.Join(dir, "wiki.db"))
+	case "git":
+		if repo == "" {
+			return newGitStore(filepath.Join(dir, "pages"))
+		}
+		return newGitStore(repo)
+	}
+	return nil, fmt.Errorf("unknown page store %q", kind)
+}
+
+// The function stamp fills in the revision fields that the store is in charge
+// of, given the number of the latest revision of the page.
+func stamp(p *Page, latest int) {
+	p.Number = latest + 1
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+}
+
+// An fsStore keeps each page in a text file named after the title of the
+// page, inside the directory dir. The revisions of the page are kept in a
+// directory next to it, one JSON file for each revision.
+// The namespaces of a title map to nested directories: the page
+// "Team/Runbooks/Deploy" is kept in Team/Runbooks/Deploy.txt. Titles are
+// validated by canonicalTitle, so they never lead outside dir.
+type fsStore struct {
+	dir string
+}
+
+// The function newFSStore creates the data directory if it does not exist yet.
+// The octal literal 0700 gives read, write and search permissions to the
+// current user only, as 0600 does for the page files.
+func newFSStore(dir string) (*fsStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fsStore{dir: dir}, nil
+}
+
+// The attachments of a page are kept in a directory next to it, with the
+// same names they were uploaded with.
+// The function FromSlash replaces the slashes in the title with the path
+// separator of the operating system.
+func (s *fsStore) filename(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(title)+".txt")
+}
+
+func (s *fsStore) historyDir(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(title)+".history")
+}
+
+func (s *fsStore) filesDir(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(title)+".files")
+}
+
+func (s *fsStore) revisionFile(title string, number int) string {
+	// Numbers are padded with zeros so the file names sort in revision order.
+	return filepath.Join(s.historyDir(title), fmt.Sprintf("%06d.json", number))
+}
+
+// A revisionFile is the content of the file of a revision. Body is a string
+// rather than a slice of bytes, which the json package would encode in base64,
+// so the files can be read by a human being.
+type revisionFile struct {
+	Revision
+	Body string
+}
+
+// The method numbers returns the numbers of the revisions of the page, in
+// ascending order.
+func (s *fsStore) numbers(title string) ([]int, error) {
+	entries, err := os.ReadDir(s.historyDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *fsStore) readRevision(title string, number int) (*Page, error) {
+	data, err := os.ReadFile(s.revisionFile(title, number))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rf revisionFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: []byte(rf.Body), Revision: rf.Revision},
+		nil
+}
+
+func (s *fsStore) Load(title string) (*Page, error) {
+	body, err := os.ReadFile(s.filename(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Page{Title: title, Body: body}
+	// A page written before revisions were introduced has no history: it is
+	// loaded as revision 0.
+	numbers, err := s.numbers(title)
+	if err != nil || len(numbers) == 0 {
+		return p, err
+	}
+	rev, err := s.readRevision(title, numbers[len(numbers)-1])
+	if err != nil {
+		return nil, err
+	}
+	p.Revision = rev.Revision
+	return p, nil
+}
+
+func (s *fsStore) Save(p *Page) error {
+	numbers, err := s.numbers(p.Title)
+	if err != nil {
+		return err
+	}
+	latest := 0
+	if len(numbers) > 0 {
+		latest = numbers[len(numbers)-1]
+	} else if latest, err = s.keepLegacy(p.Title); err != nil {
+		return err
+	}
+	stamp(p, latest)
+	if err := s.writeRevision(p); err != nil {
+		return err
+	}
+	return replaceFile(s.filename(p.Title), p.Body)
+}
+
+// The method keepLegacy turns the body of a page written before revisions
+// were introduced into its revision 1, dated from the file, so the first save
+// of the page does not lose it. It returns the number of the latest revision:
+// 1, or 0 if there is no such page.
+func (s *fsStore) keepLegacy(title string) (int, error) {
+	body, err := os.ReadFile(s.filename(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(s.filename(title))
+	if err != nil {
+		return 0, err
+	}
+	return 1, s.writeRevision(&Page{Title: title, Body: body,
+		Revision: Revision{Number: 1, Time: fi.ModTime()}})
+}
+
+// The method writeRevision writes the file of the revision p, which must not
+// exist yet.
+func (s *fsStore) writeRevision(p *Page) error {
+	data, err := json.MarshalIndent(revisionFile{Revision: p.Revision,
+		Body: string(p.Body)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// MkdirAll creates the directories of the namespaces too.
+	if err := os.MkdirAll(s.historyDir(p.Title), 0700); err != nil {
+		return err
+	}
+	return createFile(s.revisionFile(p.Title, p.Number), data)
+}
+
+// The function writeTemp writes data to a new temporary file in the directory
+// of path, and returns the name of the temporary file.
+// Readers never see a partially written file, as the data is moved to path
+// only once it is complete; Sync makes sure it has reached the disk before.
+func writeTemp(path string, data []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// The function replaceFile atomically replaces the content of the file at path
+// (or creates it): Rename swaps the new file for the old one in a single step.
+func replaceFile(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// The function writeJSONFile writes v as indented JSON to the file at path,
+// creating its directory, and replacing the file atomically; the users, the
+// watchlists and the moderation queue are kept that way. An empty path means
+// the data is kept in memory only: nothing is written.
+func writeJSONFile(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return replaceFile(path, data)
+}
+
+// The function createFile atomically creates the file at path, failing if it
+// already exists: unlike Rename, Link never replaces an existing file, so a
+// revision, once written, is never overwritten.
+func createFile(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, path)
+}
+
+func (s *fsStore) Delete(title string) error {
+	err := os.Remove(s.filename(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.historyDir(title)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.filesDir(title)); err != nil {
+		return err
+	}
+	s.removeEmptyDirs(title)
+	return nil
+}
+
+// The method removeEmptyDirs removes the directories of the namespaces of a
+// title left empty; Remove fails, stopping the loop, on the first one that is
+// not empty.
+func (s *fsStore) removeEmptyDirs(title string) {
+	root := filepath.Clean(s.dir)
+	for dir := filepath.Dir(s.filename(title)); dir != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// The page file is moved last: until then, the page is still found under its
+// old title.
+func (s *fsStore) Rename(from, to string) error {
+	if _, err := os.Stat(s.filename(from)); err != nil {
+		return ErrNotFound
+	}
+	if _, err := os.Stat(s.filename(to)); err == nil {
+		return ErrExists
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filename(to)), 0700); err != nil {
+		return err
+	}
+	for _, dir := range []func(string) string{s.historyDir, s.filesDir} {
+		err := os.Rename(dir(from), dir(to))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(s.filename(from), s.filename(to)); err != nil {
+		return err
+	}
+	s.removeEmptyDirs(from)
+	return nil
+}
+
+// The function WalkDir calls the given function for each file and directory
+// below s.dir; returning SkipDir for a directory skips its content.
+func (s *fsStore) List() ([]string, error) {
+	var titles []string
+	err := filepath.WalkDir(s.dir, func(path string, e os.DirEntry,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if strings.HasSuffix(e.Name(), ".history") ||
+				strings.HasSuffix(e.Name(), ".files") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if title, ok := strings.CutSuffix(filepath.ToSlash(rel), ".txt"); ok &&
+			validTitle(title) {
+			titles = append(titles, title)
+		}
+		return nil
+	})
+	// WalkDir visits the files in lexical order of their paths, but the order
+	// of the titles could differ from that of the paths because of the suffix.
+	sort.Strings(titles)
+	return titles, err
+}
+
+func (s *fsStore) History(title string) ([]Revision, error) {
+	numbers, err := s.numbers(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		if _, err := os.Stat(s.filename(title)); err != nil {
+			return nil, ErrNotFound
+		}
+	}
+	history := make([]Revision, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		rev, err := s.readRevision(title, numbers[i])
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, rev.Revision)
+	}
+	return history, nil
+}
+
+func (s *fsStore) LoadRevision(title string, number int) (*Page, error) {
+	return s.readRevision(title, number)
+}
+
+// The content type of an attachment kept by an fsStore is not stored: it is
+// detected again from the data each time, with the same result.
+func (s *fsStore) SaveAttachment(title string, a *Attachment,
+	data []byte) error {
+	if err := os.MkdirAll(s.filesDir(title), 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(s.filesDir(title), a.Name)
+	if err := replaceFile(path, data); err != nil {
+		return err
+	}
+	a.Size = int64(len(data))
+	if !a.Time.IsZero() {
+		// Chtimes sets the access and modification times of the file.
+		return os.Chtimes(path, a.Time, a.Time)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	a.Time = fi.ModTime()
+	return nil
+}
+
+func (s *fsStore) LoadAttachment(title, name string) (*Attachment, []byte,
+	error) {
+	path := filepath.Join(s.filesDir(title), name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Attachment{Name: name, Size: fi.Size(),
+		ContentType: sniffContentType(data), Time: fi.ModTime()}, data,
+		nil
+}
+
+func (s *fsStore) Attachments(title string) ([]Attachment, error) {
+	entries, err := os.ReadDir(s.filesDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var attachments []Attachment
+	for _, e := range entries {
+		if e.IsDir() || !validAttachmentName(e.Name()) {
+			continue
+		}
+		a, _, err := s.LoadAttachment(title, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+	return attachments, nil
+}
+
+// A memStore keeps the revisions of the pages in a map, and so loses them when
+// the program exits; it is mainly useful for tests.
+// Handlers run concurrently, one goroutine for each request, so the map is
+// guarded by a read-write mutex: many readers may hold the lock at the same
+// time, but a writer holds it alone.
+type memStore struct {
+	mu    sync.RWMutex
+	pages map[string][]Page
+	files map[string]map[string]memFile
+}
+
+type memFile struct {
+	Attachment
+	data []byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		pages: make(map[string][]Page),
+		files: make(map[string]map[string]memFile),
+	}
+}
+
+// The function copyPage returns a copy of the page with its own body, so the
+// caller cannot modify a stored page through the slice it gets back.
+func copyPage(p *Page) *Page {
+	c := *p
+	c.Body = append([]byte(nil), p.Body...)
+	return &c
+}
+
+func (s *memStore) Load(title string) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revs, ok := s.pages[title]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPage(&revs[len(revs)-1]), nil
+}
+
+func (s *memStore) Save(p *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stamp(p, len(s.pages[p.Title]))
+	s.pages[p.Title] = append(s.pages[p.Title], *copyPage(p))
+	return nil
+}
+
+func (s *memStore) Delete(title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pages[title]; !ok {
+		return ErrNotFound
+	}
+	delete(s.pages, title)
+	delete(s.files, title)
+	return nil
+}
+
+func (s *memStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, ok := s.pages[from]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.pages[to]; ok {
+		return ErrExists
+	}
+	for i := range revs {
+		revs[i].Title = to
+	}
+	s.pages[to], s.files[to] = revs, s.files[from]
+	delete(s.pages, from)
+	delete(s.files, from)
+	return nil
+}
+
+func (s *memStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	titles := make([]string, 0, len(s.pages))
+	for title := range s.pages {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+func (s *memStore) History(title string) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revs, ok := s.pages[title]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	history := make([]Revision, 0, len(revs))
+	for i := len(revs) - 1; i >= 0; i-- {
+		history = append(history, revs[i].Revision)
+	}
+	return history, nil
+}
+
+func (s *memStore) LoadRevision(title string, number int) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revs := s.pages[title]
+	if number < 1 || number > len(revs) {
+		return nil, ErrNotFound
+	}
+	return copyPage(&revs[number-1]), nil
+}
+
+func (s *memStore) SaveAttachment(title string, a *Attachment,
+	data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.Size = int64(len(data))
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	if s.files[title] == nil {
+		s.files[title] = make(map[string]memFile)
+	}
+	s.files[title][a.Name] = memFile{*a, append([]byte(nil), data...)}
+	return nil
+}
+
+func (s *memStore) LoadAttachment(title, name string) (*Attachment, []byte,
+	error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.files[title][name]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return &f.Attachment, append([]byte(nil), f.data...), nil
+}
+
+func (s *memStore) Attachments(title string) ([]Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var attachments []Attachment
+	for _, f := range s.files[title] {
+		attachments = append(attachments, f.Attachment)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].Name < attachments[j].Name
+	})
+	return attachments, nil
+}
@@ -0,0 +1,446 @@
+package gowiki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A gitStore keeps the pages as Markdown files in the working tree of a local
+// git repository, and commits each save, so the history of the wiki can be
+// reviewed and backed up with the usual git tools. The page "Team/Runbook" is
+// kept in Team/Runbook.md, and its attachments in Team/Runbook.files.
+// The revisions of a page are the commits that changed its file, followed
+// through renames, numbered from 1 for the oldest one: git only records
+// changes, so a save that leaves the body as it was adds no revision.
+// The store runs the git command, which must be installed; it never talks to
+// a remote.
+type gitStore struct {
+	dir string
+	// mu serializes the git commands, which would otherwise compete for the
+	// index of the repository, and guards the cache of the histories.
+	mu      sync.Mutex
+	history map[string][]gitRevision
+}
+
+// A gitRevision is a revision with the commit that made it, and the path the
+// file of the page had then.
+type gitRevision struct {
+	Revision
+	commit string
+	path   string
+}
+
+// The function newGitStore opens the repository in dir, creating it if needed.
+// A repository that already holds Markdown files is imported as it is: the
+// files become pages, with the history they have, and the files not yet
+// committed are committed first.
+func newGitStore(dir string) (*gitStore, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &gitStore{dir: dir, history: make(map[string][]gitRevision)}
+	// Only a repository whose top is dir will do: without this check, git
+	// would find the repository of a directory containing dir.
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err,
+		os.ErrNotExist) {
+		if _, err := s.git(nil, "init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+	// Git cannot show the log of a repository without commits, so a new one
+	// starts with an empty commit.
+	if _, err := s.git(nil, "rev-parse", "-q", "--verify", "HEAD"); err != nil {
+		_, err := s.git(gitIdentity("gowiki", time.Now()), "commit", "-q",
+			"--allow-empty", "-m", "Create the wiki")
+		if err != nil {
+			return nil, err
+		}
+	}
+	status, err := s.git(nil, "status", "--porcelain", "--", "*.md")
+	if err != nil {
+		return nil, err
+	}
+	if len(status) > 0 {
+		if _, err := s.git(nil, "add", "-A", "--", "*.md"); err != nil {
+			return nil, err
+		}
+		err := s.commit("gowiki", "Import pages", time.Now(), "*.md")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// The method git runs a git command in the repository, with the additional
+// environment variables env, and returns its output. The error includes what
+// git wrote to its standard error.
+func (s *gitStore) git(env []string, args ...string) ([]byte, error) {
+	// The option core.quotePath=false makes git write the paths as they are,
+	// instead of quoting those with non-ASCII characters.
+	cmd := exec.Command("git", append([]string{"-C", s.dir, "-c",
+		"core.quotePath=false", "-c", "commit.gpgSign=false"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	// Output collects the standard error in the ExitError it returns.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("git %s: %s", args[0],
+			bytes.TrimSpace(exitErr.Stderr))
+	}
+	return out, err
+}
+
+// The method commit commits the changes staged in paths, written by author at
+// t with the message comment.
+func (s *gitStore) commit(author, comment string, t time.Time,
+	paths ...string) error {
+	args := append([]string{"commit", "-q", "--allow-empty-message", "-m",
+		comment, "--"}, paths...)
+	_, err := s.git(gitIdentity(author, t), args...)
+	return err
+}
+
+// The function gitIdentity returns the environment variables that set the
+// author of a commit and its time; the committer is always the wiki itself.
+func gitIdentity(author string, t time.Time) []string {
+	// Git refuses commits without an author name; it accepts empty emails.
+	if author == "" {
+		author = "anonymous"
+	}
+	return []string{
+		"GIT_AUTHOR_NAME=" + author,
+		"GIT_AUTHOR_EMAIL=",
+		fmt.Sprintf("GIT_AUTHOR_DATE=@%d +0000", t.Unix()),
+		"GIT_COMMITTER_NAME=gowiki",
+		"GIT_COMMITTER_EMAIL=",
+	}
+}
+
+func (s *gitStore) filename(title string) string {
+	return title + ".md"
+}
+
+func (s *gitStore) filesDir(title string) string {
+	return title + ".files"
+}
+
+// The function abs returns the path on disk of a path of the repository.
+func (s *gitStore) abs(path string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path))
+}
+
+// The method revisions returns the revisions of a page, the latest first, from
+// the log of its file; the caller must hold the lock.
+// Each commit of the log is written as the fields of the format, separated by
+// the character 0x1f, followed by the status of the file ("M", "A", "D", or
+// "R" with the old and the new path); the commits are separated by 0x1e.
+func (s *gitStore) revisions(title string) ([]gitRevision, error) {
+	if revs, ok := s.history[title]; ok {
+		return revs, nil
+	}
+	out, err := s.git(nil, "log", "--follow", "--name-status",
+		"--format=%x1e%H%x1f%an%x1f%at%x1f%B%x1f", "--",
+		s.filename(title))
+	if err != nil {
+		return nil, err
+	}
+	var revs []gitRevision
+loop:
+	for _, record := range strings.Split(string(out), "\x1e")[1:] {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("git log: unexpected output %q", record)
+		}
+		status := strings.Split(strings.TrimSpace(fields[4]), "\t")
+		// Merges do not list the changes they bring.
+		if len(status) < 2 {
+			continue
+		}
+		at, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		rev := gitRevision{
+			Revision: Revision{Author: fields[1],
+				Comment: strings.TrimRight(fields[3], "\n"),
+				Time:    time.Unix(at, 0)},
+			commit: fields[0],
+			path:   status[len(status)-1],
+		}
+		// The history of the page starts where its file was added: an older
+		// deletion belongs to a deleted page with the same title.
+		switch status[0][0] {
+		case 'D':
+			break loop
+		case 'A', 'C':
+			revs = append(revs, rev)
+			break loop
+		}
+		revs = append(revs, rev)
+	}
+	for i := range revs {
+		revs[i].Number = len(revs) - i
+	}
+	s.history[title] = revs
+	return revs, nil
+}
+
+func (s *gitStore) Load(title string) (*Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, err := os.ReadFile(s.abs(s.filename(title)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Page{Title: title, Body: body}
+	revs, err := s.revisions(title)
+	if err != nil || len(revs) == 0 {
+		return p, err
+	}
+	p.Revision = revs[0].Revision
+	return p, nil
+}
+
+func (s *gitStore) Save(p *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, err := s.revisions(p.Title)
+	if err != nil {
+		return err
+	}
+	latest := 0
+	if len(revs) > 0 {
+		latest = revs[0].Number
+	}
+	stamp(p, latest)
+	// Git keeps the time to the second.
+	p.Time = p.Time.Truncate(time.Second)
+	path := s.filename(p.Title)
+	if err := os.MkdirAll(filepath.Dir(s.abs(path)), 0700); err != nil {
+		return err
+	}
+	if err := replaceFile(s.abs(path), p.Body); err != nil {
+		return err
+	}
+	delete(s.history, p.Title)
+	status, err := s.git(nil, "status", "--porcelain", "--", path)
+	if err != nil {
+		return err
+	}
+	if len(status) == 0 {
+		if latest > 0 {
+			p.Revision = revs[0].Revision
+		}
+		return nil
+	}
+	if _, err := s.git(nil, "add", "--", path); err != nil {
+		return err
+	}
+	return s.commit(p.Author, p.Comment, p.Time, path)
+}
+
+// The method skipsUnchanged tells the import of archives that a save leaving
+// the body as it was adds no revision (see ImportArchive).
+func (s *gitStore) skipsUnchanged() bool {
+	return true
+}
+
+func (s *gitStore) Delete(title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.filename(title)
+	if _, err := os.Stat(s.abs(path)); err != nil {
+		return ErrNotFound
+	}
+	delete(s.history, title)
+	// The option --ignore-unmatch lets the page have no attachments; git rm
+	// also removes the directories it leaves empty.
+	_, err := s.git(nil, "rm", "-q", "-r", "--ignore-unmatch", "--", path,
+		s.filesDir(title))
+	if err != nil {
+		return err
+	}
+	return s.commit("gowiki", "Delete "+title, time.Now(), path,
+		s.filesDir(title))
+}
+
+func (s *gitStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.abs(s.filename(from))); err != nil {
+		return ErrNotFound
+	}
+	if _, err := os.Stat(s.abs(s.filename(to))); err == nil {
+		return ErrExists
+	}
+	delete(s.history, from)
+	delete(s.history, to)
+	if err := os.MkdirAll(filepath.Dir(s.abs(s.filename(to))), 0700); err != nil {
+		return err
+	}
+	paths := []string{s.filename(from), s.filename(to)}
+	if _, err := s.git(nil, "mv", "--", paths[0], paths[1]); err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.abs(s.filesDir(from))); err == nil {
+		_, err := s.git(nil, "mv", "--", s.filesDir(from), s.filesDir(to))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, s.filesDir(from), s.filesDir(to))
+	}
+	return s.commit("gowiki", "Rename "+from+" to "+to, time.Now(), paths...)
+}
+
+// The files of the pages are listed from the index of the repository, so the
+// other files of its working tree are ignored.
+func (s *gitStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, err := s.git(nil, "ls-files", "-z", "--", "*.md")
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if title, ok := strings.CutSuffix(path, ".md"); ok && validTitle(title) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+func (s *gitStore) History(title string) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, err := s.revisions(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+	history := make([]Revision, len(revs))
+	for i, rev := range revs {
+		history[i] = rev.Revision
+	}
+	return history, nil
+}
+
+// The body of an old revision is read from its commit with git show, which
+// accepts the name of a file as <commit>:<path>.
+func (s *gitStore) LoadRevision(title string, number int) (*Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, err := s.revisions(title)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 || number > len(revs) {
+		return nil, ErrNotFound
+	}
+	rev := revs[len(revs)-number]
+	body, err := s.git(nil, "show", rev.commit+":"+rev.path)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body, Revision: rev.Revision}, nil
+}
+
+// The attachments are committed too, although the wiki keeps no history for
+// them; as in an fsStore, their time is the modification time of the file.
+func (s *gitStore) SaveAttachment(title string, a *Attachment,
+	data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := s.abs(s.filesDir(title))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, a.Name)
+	if err := replaceFile(file, data); err != nil {
+		return err
+	}
+	a.Size = int64(len(data))
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	if err := os.Chtimes(file, a.Time, a.Time); err != nil {
+		return err
+	}
+	path := s.filesDir(title) + "/" + a.Name
+	if _, err := s.git(nil, "add", "--", path); err != nil {
+		return err
+	}
+	status, err := s.git(nil, "status", "--porcelain", "--", path)
+	if err != nil || len(status) == 0 {
+		return err
+	}
+	return s.commit("gowiki", "Attach "+a.Name+" to "+title, a.Time, path)
+}
+
+func (s *gitStore) LoadAttachment(title, name string) (*Attachment, []byte,
+	error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadAttachment(title, name)
+}
+
+func (s *gitStore) loadAttachment(title, name string) (*Attachment, []byte,
+	error) {
+	file := filepath.Join(s.abs(s.filesDir(title)), name)
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Attachment{Name: name, Size: fi.Size(),
+		ContentType: sniffContentType(data), Time: fi.ModTime()}, data, nil
+}
+
+func (s *gitStore) Attachments(title string) ([]Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.abs(s.filesDir(title)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var attachments []Attachment
+	for _, e := range entries {
+		if e.IsDir() || !validAttachmentName(e.Name()) {
+			continue
+		}
+		a, _, err := s.loadAttachment(title, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+	return attachments, nil
+}
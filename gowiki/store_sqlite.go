@@ -0,0 +1,293 @@
+package gowiki
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// A sqliteStore keeps the revisions of the pages in a table of an SQLite
+// database.
+// The database/sql package only defines the interface towards a database: the
+// driver that actually speaks to SQLite must be registered under the name
+// "sqlite3" by a package imported for its side effects only (see
+// store_sqlite_driver.go, compiled with the build tag "sqlite").
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// The function newSQLiteStore opens the database file at path and creates the
+// revisions table if it does not exist yet.
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	// Drivers lists the drivers registered with database/sql.
+	if !slices.Contains(sql.Drivers(), "sqlite3") {
+		return nil, errors.New("the sqlite store needs a gowiki built " +
+			"with -tags sqlite")
+	}
+	// The transactions start with BEGIN IMMEDIATE, which takes the write
+	// lock at once: a transaction that reads, then writes, as Save does,
+	// would otherwise fail with SQLITE_BUSY when another one got the lock in
+	// the meantime. A connection waits up to 5 seconds for the lock, instead
+	// of failing at once. Both are parameters of the go-sqlite3 driver.
+	db, err := sql.Open("sqlite3",
+		path+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// Open may just validate its arguments without creating a connection, so
+	// Ping checks that the database is really reachable.
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS revisions (
+		title   TEXT NOT NULL,
+		number  INTEGER NOT NULL,
+		author  TEXT NOT NULL,
+		comment TEXT NOT NULL,
+		time    TEXT NOT NULL,
+		body    BLOB NOT NULL,
+		PRIMARY KEY (title, number)
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS attachments (
+			title        TEXT NOT NULL,
+			name         TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			time         TEXT NOT NULL,
+			data         BLOB NOT NULL,
+			PRIMARY KEY (title, name)
+		)`)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// The time of a revision is stored as text in RFC 3339 format, which every
+// SQLite driver can read back and which sorts in chronological order.
+func (s *sqliteStore) scanPage(row *sql.Row, title string) (*Page, error) {
+	p := &Page{Title: title}
+	var t string
+	err := row.Scan(&p.Number, &p.Author, &p.Comment, &t, &p.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *sqliteStore) Load(title string) (*Page, error) {
+	return s.scanPage(s.db.QueryRow(`SELECT number, author, comment, time, body
+		FROM revisions WHERE title = ? ORDER BY number DESC LIMIT 1`, title),
+		title)
+}
+
+func (s *sqliteStore) Save(p *Page) error {
+	// The number of the new revision is computed and used within the same
+	// transaction, so two concurrent saves cannot get the same number.
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback has no effect once the transaction has been committed.
+	defer tx.Rollback()
+	var latest int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(number), 0) FROM revisions
+		WHERE title = ?`, p.Title).Scan(&latest)
+	if err != nil {
+		return err
+	}
+	stamp(p, latest)
+	_, err = tx.Exec(`INSERT INTO revisions
+		(title, number, author, comment, time, body)
+		VALUES (?, ?, ?, ?, ?, ?)`, p.Title, p.Number, p.Author, p.Comment,
+		p.Time.UTC().Format(time.RFC3339Nano), p.Body)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Delete(title string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM revisions WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM attachments WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Rename(from, to string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	err = tx.QueryRow("SELECT COUNT(*) FROM revisions WHERE title = ?",
+		to).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrExists
+	}
+	result, err := tx.Exec("UPDATE revisions SET title = ? WHERE title = ?",
+		to, from)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return err
+	}
+	_, err = tx.Exec("UPDATE attachments SET title = ? WHERE title = ?", to,
+		from)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) List() ([]string, error) {
+	rows, err := s.db.Query(
+		"SELECT DISTINCT title FROM revisions ORDER BY title")
+	if err != nil {
+		return nil, err
+	}
+	// Deferring rows.Close releases the connection when the function returns,
+	// whatever the path it takes.
+	defer rows.Close()
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+func (s *sqliteStore) History(title string) ([]Revision, error) {
+	rows, err := s.db.Query(`SELECT number, author, comment, time
+		FROM revisions WHERE title = ? ORDER BY number DESC`, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []Revision
+	for rows.Next() {
+		var rev Revision
+		var t string
+		if err := rows.Scan(&rev.Number, &rev.Author, &rev.Comment,
+			&t); err != nil {
+			return nil, err
+		}
+		if rev.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, err
+		}
+		history = append(history, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, ErrNotFound
+	}
+	return history, nil
+}
+
+func (s *sqliteStore) LoadRevision(title string, number int) (*Page, error) {
+	return s.scanPage(s.db.QueryRow(`SELECT number, author, comment, time, body
+		FROM revisions WHERE title = ? AND number = ?`, title, number), title)
+}
+
+func (s *sqliteStore) SaveAttachment(title string, a *Attachment,
+	data []byte) error {
+	a.Size = int64(len(data))
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	_, err := s.db.Exec(`INSERT INTO attachments
+		(title, name, content_type, time, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (title, name) DO UPDATE SET
+		content_type = excluded.content_type, time = excluded.time,
+		data = excluded.data`, title, a.Name, a.ContentType,
+		a.Time.UTC().Format(time.RFC3339Nano), data)
+	return err
+}
+
+func (s *sqliteStore) LoadAttachment(title, name string) (*Attachment, []byte,
+	error) {
+	a := &Attachment{Name: name}
+	var t string
+	var data []byte
+	err := s.db.QueryRow(`SELECT content_type, time, data FROM attachments
+		WHERE title = ? AND name = ?`, title, name).Scan(&a.ContentType, &t,
+		&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+		return nil, nil, err
+	}
+	a.Size = int64(len(data))
+	return a, data, nil
+}
+
+func (s *sqliteStore) Attachments(title string) ([]Attachment, error) {
+	rows, err := s.db.Query(`SELECT name, content_type, time, LENGTH(data)
+		FROM attachments WHERE title = ? ORDER BY name`, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attachments []Attachment
+	for rows.Next() {
+		var a Attachment
+		var t string
+		if err := rows.Scan(&a.Name, &a.ContentType, &t, &a.Size); err != nil {
+			return nil, err
+		}
+		if a.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// The method Close closes the database; the program calls it when it stops.
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,8 @@
+//go:build sqlite
+
+package gowiki
+
+// The blank identifier imports the SQLite driver only for its side effect of
+// registering itself with database/sql under the name "sqlite3".
+// Build with "go build -tags sqlite" to include it.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,50 @@
+//go:build sqlite
+
+package gowiki
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "wiki.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+// Concurrent saves of a page all succeed, each with its own number.
+func TestSQLiteConcurrentSaves(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "wiki.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Save(&Page{Title: "FrontPage",
+				Body: []byte(fmt.Sprint("save ", i))})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := s.History("FrontPage")
+	if err != nil || len(history) != n || history[0].Number != n {
+		t.Fatalf("History() = %d revisions, %v, want %d", len(history), err,
+			n)
+	}
+}
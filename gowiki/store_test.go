@@ -0,0 +1,213 @@
+package gowiki
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The function testStore runs the same checks against any PageStore, so every
+// backend is held to the same contract.
+func testStore(t *testing.T, s PageStore) {
+	if _, err := s.Load("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf(`Load("Missing") error = %v, want ErrNotFound`, err)
+	}
+	for _, p := range []*Page{
+		{Title: "Beta", Body: []byte("first")},
+		{Title: "Alpha", Body: []byte("alpha")},
+		{Title: "Beta", Body: []byte("second")},
+		{Title: "Team/Café", Body: []byte("namespaced")},
+	} {
+		if err := s.Save(p); err != nil {
+			t.Fatalf("Save(%q) error = %v", p.Title, err)
+		}
+	}
+	p, err := s.Load("Beta")
+	if err != nil || string(p.Body) != "second" {
+		t.Fatalf(`Load("Beta") = %v, %v, want body "second"`, p, err)
+	}
+	if p.Number != 2 {
+		t.Fatalf(`Load("Beta").Number = %d, want 2`, p.Number)
+	}
+	history, err := s.History("Beta")
+	if err != nil || len(history) != 2 || history[0].Number != 2 {
+		t.Fatalf(`History("Beta") = %v, %v, want revisions 2 and 1`, history,
+			err)
+	}
+	old, err := s.LoadRevision("Beta", 1)
+	if err != nil || string(old.Body) != "first" {
+		t.Fatalf(`LoadRevision("Beta", 1) = %v, %v, want body "first"`, old,
+			err)
+	}
+	if _, err := s.LoadRevision("Beta", 3); !errors.Is(err, ErrNotFound) {
+		t.Fatalf(`LoadRevision("Beta", 3) error = %v, want ErrNotFound`, err)
+	}
+	titles, err := s.List()
+	if want := []string{"Alpha", "Beta", "Team/Café"}; err != nil ||
+		!reflect.DeepEqual(titles, want) {
+		t.Fatalf("List() = %q, %v, want %q", titles, err, want)
+	}
+	a := &Attachment{Name: "notes.txt", ContentType: "text/plain"}
+	if err := s.SaveAttachment("Alpha", a, []byte("hello")); err != nil {
+		t.Fatalf("SaveAttachment() error = %v", err)
+	}
+	attachments, err := s.Attachments("Alpha")
+	if err != nil || len(attachments) != 1 || attachments[0].Size != 5 {
+		t.Fatalf(`Attachments("Alpha") = %v, %v, want notes.txt`, attachments,
+			err)
+	}
+	if _, data, err := s.LoadAttachment("Alpha", "notes.txt"); err != nil ||
+		string(data) != "hello" {
+		t.Fatalf("LoadAttachment() = %q, %v, want \"hello\"", data, err)
+	}
+	if err := s.Rename("Alpha", "Beta"); !errors.Is(err, ErrExists) {
+		t.Fatalf(`Rename("Alpha", "Beta") error = %v, want ErrExists`, err)
+	}
+	if err := s.Rename("Alpha", "Team/Gamma"); err != nil {
+		t.Fatalf(`Rename("Alpha", "Team/Gamma") error = %v`, err)
+	}
+	if _, data, err := s.LoadAttachment("Team/Gamma", "notes.txt"); err != nil ||
+		string(data) != "hello" {
+		t.Fatalf("LoadAttachment() after Rename = %q, %v", data, err)
+	}
+	if err := s.Rename("Team/Gamma", "Alpha"); err != nil {
+		t.Fatalf(`Rename("Team/Gamma", "Alpha") error = %v`, err)
+	}
+	if p, err := s.Load("Alpha"); err != nil || p.Title != "Alpha" {
+		t.Fatalf(`Load("Alpha") after Rename = %v, %v`, p, err)
+	}
+	if err := s.Delete("Alpha"); err != nil {
+		t.Fatalf(`Delete("Alpha") error = %v`, err)
+	}
+	if err := s.Delete("Alpha"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf(`second Delete("Alpha") error = %v, want ErrNotFound`, err)
+	}
+	if _, _, err := s.LoadAttachment("Alpha", "notes.txt"); !errors.Is(err,
+		ErrNotFound) {
+		t.Fatalf("LoadAttachment() of a deleted page error = %v", err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, newMemStore())
+}
+
+// The method TempDir of testing.T returns a directory that is removed when
+// the test ends.
+func TestFSStore(t *testing.T) {
+	s, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	// A page written before revisions were introduced is a bare text file,
+	// loaded as revision 0; its first save keeps the old body as revision 1.
+	legacy := filepath.Join(s.dir, "Legacy.txt")
+	if err := os.WriteFile(legacy, []byte("old text"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.Load("Legacy"); err != nil || p.Number != 0 {
+		t.Fatalf(`Load("Legacy") = %v, %v, want revision 0`, p, err)
+	}
+	err = s.Save(&Page{Title: "Legacy", Body: []byte("new text")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := s.History("Legacy")
+	if err != nil || len(history) != 2 || history[0].Number != 2 {
+		t.Fatalf(`History("Legacy") = %v, %v, want revisions 2 and 1`,
+			history, err)
+	}
+	old, err := s.LoadRevision("Legacy", 1)
+	if err != nil || string(old.Body) != "old text" {
+		t.Fatalf(`LoadRevision("Legacy", 1) = %v, %v, want body "old text"`,
+			old, err)
+	}
+	if p, err := s.Load("Legacy"); err != nil || p.Number != 2 ||
+		string(p.Body) != "new text" {
+		t.Fatalf(`Load("Legacy") after Save = %v, %v`, p, err)
+	}
+}
+
+func TestGitStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	s, err := newGitStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+// An existing repository of Markdown files is imported with its history.
+func TestGitImport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	s, err := newGitStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, body string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Notes.md", "first")
+	write("Team/Plan.md", "plan")
+	write("README.txt", "not a page")
+	if _, err := s.git(nil, "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commit("alice", "Start", time.Unix(1e9, 0)); err != nil {
+		t.Fatal(err)
+	}
+	write("Notes.md", "second")
+	if s, err = newGitStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	titles, err := s.List()
+	if want := []string{"Notes", "Team/Plan"}; err != nil ||
+		!reflect.DeepEqual(titles, want) {
+		t.Fatalf("List() = %q, %v, want %q", titles, err, want)
+	}
+	history, err := s.History("Notes")
+	if err != nil || len(history) != 2 || history[1].Author != "alice" ||
+		history[1].Comment != "Start" || history[0].Comment != "Import pages" {
+		t.Fatalf(`History("Notes") = %v, %v`, history, err)
+	}
+	if p, err := s.LoadRevision("Notes", 1); err != nil ||
+		string(p.Body) != "first" {
+		t.Fatalf(`LoadRevision("Notes", 1) = %v, %v`, p, err)
+	}
+}
+
+func TestWriteJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "watch.json")
+	if err := writeJSONFile(path, map[string][]string{"alice": {"FrontPage"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if want := "{\n  \"alice\": [\n    \"FrontPage\"\n  ]\n}"; err != nil ||
+		string(data) != want {
+		t.Errorf("file %q, %v, want %q", data, err, want)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("file mode %v, %v", fi.Mode(), err)
+	}
+	// Without a path, nothing is written.
+	if err := writeJSONFile("", struct{}{}); err != nil {
+		t.Error(err)
+	}
+}
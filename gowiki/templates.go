@@ -0,0 +1,278 @@
+package gowiki
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The templates and the themes are embedded in the binary, so the wiki does not
+// depend on the directory it is started from.
+// The go:embed directive initializes the variable with the files matching the
+// patterns, read at compile time.
+//
+//go:embed templates/*.html themes/*.css
+var assets embed.FS
+
+// The method readAsset returns the content of an embedded file, such as
+// "templates/view.html", or of the file overriding it.
+func (wk *Wiki) readAsset(name string) ([]byte, error) {
+	if wk.templateFS != nil {
+		data, err := fs.ReadFile(wk.templateFS, name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+	}
+	return assets.ReadFile(name)
+}
+
+// The method assetNames returns the base names of the files in an embedded
+// directory, together with the new ones found in the override directory,
+// whose names match the given pattern.
+func (wk *Wiki) assetNames(dir, pattern string) ([]string, error) {
+	names, err := fs.Glob(assets, dir+"/"+pattern)
+	if err != nil {
+		return nil, err
+	}
+	if wk.templateFS != nil {
+		more, err := fs.Glob(wk.templateFS, dir+"/"+pattern)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, more...)
+	}
+	seen := make(map[string]bool)
+	var bases []string
+	for _, name := range names {
+		base := path.Base(name)
+		if !seen[base] {
+			seen[base] = true
+			bases = append(bases, base)
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
+}
+
+// The method themes returns the names of the available themes; it is also
+// available to the templates.
+func (wk *Wiki) themes() []string {
+	names, err := wk.assetNames("themes", "*.css")
+	if err != nil {
+		return nil
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".css")
+	}
+	return names
+}
+
+// The method loadTemplates parses the templates. Each page template is
+// parsed into its own copy of the base layout: the "content" templates of the
+// pages have the same name, so they could not live in the same set.
+func (wk *Wiki) loadTemplates() (map[string]*template.Template, error) {
+	data, err := wk.readAsset("templates/base.html")
+	if err != nil {
+		return nil, err
+	}
+	// Funcs makes Go functions available to the template actions; it must be
+	// called before the templates using them are parsed.
+	base, err := template.New("base.html").Funcs(template.FuncMap{
+		"themes": wk.themes,
+		"root":   func() string { return wk.basePath },
+	}).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	names, err := wk.assetNames("templates", "*.html")
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]*template.Template)
+	for _, name := range names {
+		if name == "base.html" {
+			continue
+		}
+		data, err := wk.readAsset("templates/" + name)
+		if err != nil {
+			return nil, err
+		}
+		// Clone returns a copy of the base layout, to which the page template
+		// is added.
+		t, err := template.Must(base.Clone()).New(name).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		set[strings.TrimSuffix(name, ".html")] = t
+	}
+	return set, nil
+}
+
+// A templateSet holds the templates currently in use: they are replaced as a
+// whole when they are reloaded, while requests are being served.
+type templateSet struct {
+	sync.RWMutex
+	set map[string]*template.Template
+}
+
+// The method reloadTemplates replaces the templates in use; if the new ones
+// are broken, the old ones are kept.
+func (wk *Wiki) reloadTemplates() error {
+	set, err := wk.loadTemplates()
+	if err != nil {
+		return err
+	}
+	wk.templates.Lock()
+	wk.templates.set = set
+	wk.templates.Unlock()
+	// The pages rendered with the old templates are not valid anymore.
+	wk.rendered.invalidate()
+	return nil
+}
+
+// The method WatchTemplates checks the override directory every interval,
+// and reloads the templates when a file has changed. The standard library
+// has no way to be notified of changes, so it compares the modification times
+// and the sizes of the files.
+func (wk *Wiki) WatchTemplates(interval time.Duration) {
+	last := ""
+	for {
+		var b strings.Builder
+		fs.WalkDir(wk.templateFS, ".", func(p string, e fs.DirEntry,
+			err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := e.Info(); err == nil {
+				fmt.Fprintf(&b, "%s %d %d\n", p, info.ModTime().UnixNano(),
+					info.Size())
+			}
+			return nil
+		})
+		if state := b.String(); state != last {
+			if last != "" {
+				if err := wk.reloadTemplates(); err != nil {
+					log.Printf("templates not reloaded: %v", err)
+				} else {
+					log.Print("templates reloaded")
+				}
+			}
+			last = state
+		}
+		time.Sleep(interval)
+	}
+}
+
+// The data passed to a template is usually a *Page, but any value will do: the
+// empty interface type any is satisfied by values of every type.
+func (wk *Wiki) renderTemplate(w http.ResponseWriter, tmpl string, data any) {
+	wk.renderStatus(w, http.StatusOK, tmpl, data)
+}
+
+// The method executeTemplate returns the page generated by a template, in the
+// base layout.
+func (wk *Wiki) executeTemplate(tmpl string, data any) ([]byte, error) {
+	wk.templates.RLock()
+	t := wk.templates.set[tmpl]
+	wk.templates.RUnlock()
+	if t == nil {
+		return nil, fmt.Errorf("no template %s", tmpl)
+	}
+	var buf bytes.Buffer
+	// The method ExecuteTemplate executes the named template, writing the
+	// generated HTML to the buffer.
+	if err := t.ExecuteTemplate(&buf, "base", data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The method renderStatus renders a template with the given status code.
+// The page is generated in memory first, so that a failing template results in
+// a clean error instead of half a page.
+func (wk *Wiki) renderStatus(w http.ResponseWriter, status int, tmpl string,
+	data any) {
+	page, err := wk.executeTemplate(tmpl, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The headers must be set before WriteHeader sends them.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(page)
+}
+
+// The method httpError answers a request with an error page, in the layout
+// of the wiki, explaining what went wrong; it replaces http.Error, which
+// answers with the bare message as plain text.
+func (wk *Wiki) httpError(w http.ResponseWriter, message string, status int) {
+	wk.renderStatus(w, status, "error", struct {
+		Status     int
+		StatusText string
+		Message    string
+	}{status, http.StatusText(status), message})
+}
+
+const themeCookie = "theme"
+
+// The method themeCSSHandler serves the style sheet of the theme chosen by
+// the client, or of the default theme; it handles the URL "/theme.css".
+func (wk *Wiki) themeCSSHandler(w http.ResponseWriter, r *http.Request) {
+	name := wk.defaultTheme
+	if c, err := r.Cookie(themeCookie); err == nil && wk.validTheme(c.Value) {
+		name = c.Value
+	}
+	data, err := wk.readAsset("themes/" + name + ".css")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+func (wk *Wiki) validTheme(name string) bool {
+	for _, t := range wk.themes() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The method themeHandler records the theme chosen by the client, given by
+// the query parameter "name", in a cookie, and goes back to the page the
+// client came from; it handles the URL "/theme".
+func (wk *Wiki) themeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if !wk.validTheme(name) {
+		wk.httpError(w, "There is no such theme.", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    name,
+		Path:     wk.basePath + "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		Secure:   wk.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	back := wk.basePath + "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host {
+		back = safeNext(u.RequestURI())
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
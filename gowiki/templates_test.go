@@ -0,0 +1,44 @@
+package gowiki
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	wk := newTestWiki(t, Options{Store: newMemStore(),
+		Templates: os.DirFS(dir)})
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.MkdirAll(filepath.Join(dir, "themes"), 0755)
+	os.WriteFile(filepath.Join(dir, "themes", "plain.css"), nil, 0644)
+	login := filepath.Join(dir, "templates", "login.html")
+	os.WriteFile(login, []byte(`{{define "content"}}custom login{{end}}`),
+		0644)
+	if err := wk.reloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	wk.renderTemplate(w, "login", nil)
+	body := w.Body.String()
+	if !strings.Contains(body, "custom login") {
+		t.Errorf("overridden template not used: %s", body)
+	}
+	if !strings.Contains(body, "/theme?name=plain") {
+		t.Errorf("new theme not listed: %s", body)
+	}
+
+	// A broken template is reported, and the previous ones are kept.
+	os.WriteFile(login, []byte(`{{define "content"}}{{.Nope`), 0644)
+	if err := wk.reloadTemplates(); err == nil {
+		t.Error("broken template loaded")
+	}
+	w = httptest.NewRecorder()
+	wk.renderTemplate(w, "login", nil)
+	if !strings.Contains(w.Body.String(), "custom login") {
+		t.Errorf("previous templates not kept: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,137 @@
+package gowiki
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Titles are made of one or more segments separated by slashes, as in
+// "Team/Runbooks/Deploy": each segment but the last one names a namespace.
+// A segment is made of Unicode letters (with their combining marks), digits,
+// underscores and hyphens, and starts with a letter or a digit, so it can never
+// be "." or "..": a title maps safely to a path below the data directory.
+var validSegment = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{M}\p{N}_-]*$`)
+
+// The function canonicalTitle returns the canonical form of a title: the
+// white space is removed ("Page Name" becomes "PageName", as in the links) and
+// so are the empty segments, so "/Team//Runbooks/" becomes "Team/Runbooks".
+// It returns false if the title is not valid.
+// The characters are put in Unicode normalization form C, where "é" is a
+// single code point, rather than "e" followed by a combining accent (form D,
+// which some systems use for file names): both spellings name the same page.
+func canonicalTitle(raw string) (string, bool) {
+	var segments []string
+	for _, seg := range strings.Split(norm.NFC.String(raw), "/") {
+		seg = strings.Join(strings.FieldsFunc(seg, unicode.IsSpace), "")
+		if seg == "" {
+			continue
+		}
+		if !validSegment.MatchString(seg) {
+			return "", false
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return "", false
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// The function validTitle tells whether title is a valid title in canonical
+// form.
+func validTitle(title string) bool {
+	canonical, ok := canonicalTitle(title)
+	return ok && canonical == title
+}
+
+// The method pageURL returns the URL of an action on a page, such as
+// "/view/Caf%C3%A9", escaping the title but not its slashes. The URL starts
+// with the prefix the wiki is mounted under, if any.
+func (wk *Wiki) pageURL(action, title string) string {
+	u := url.URL{Path: wk.basePath + "/" + action + "/" + title}
+	return u.EscapedPath()
+}
+
+// A crumb is a link in the trail of namespaces leading to a page.
+type crumb struct {
+	Name string // The last segment of the namespace.
+	Path string // The whole namespace.
+}
+
+// The function breadcrumbs returns the namespaces containing a title (or a
+// namespace), from the outermost one.
+func breadcrumbs(title string) []crumb {
+	segments := strings.Split(title, "/")
+	crumbs := make([]crumb, 0, len(segments)-1)
+	for i := range len(segments) - 1 {
+		crumbs = append(crumbs, crumb{segments[i],
+			strings.Join(segments[:i+1], "/")})
+	}
+	return crumbs
+}
+
+// The function namespaceEntries returns the pages and the namespaces found
+// directly inside a namespace ("" for the root of the wiki), given the titles
+// of all the pages.
+func namespaceEntries(ns string, titles []string) (pages, namespaces []crumb) {
+	prefix := ""
+	if ns != "" {
+		prefix = ns + "/"
+	}
+	seen := make(map[string]bool)
+	for _, title := range titles {
+		rest, ok := strings.CutPrefix(title, prefix)
+		if !ok {
+			continue
+		}
+		if name, _, nested := strings.Cut(rest, "/"); nested {
+			if !seen[name] {
+				seen[name] = true
+				namespaces = append(namespaces, crumb{name, prefix + name})
+			}
+		} else {
+			pages = append(pages, crumb{rest, title})
+		}
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return pages, namespaces
+}
+
+// The method indexHandler lists the pages and the namespaces inside a
+// namespace; it handles the URLs prefixed with "/index/" ("/index/" alone is
+// the root of the wiki).
+func (wk *Wiki) indexHandler(w http.ResponseWriter, r *http.Request) {
+	ns := ""
+	if raw := r.PathValue("namespace"); strings.Trim(raw, "/") != "" {
+		var ok bool
+		if ns, ok = canonicalTitle(raw); !ok {
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		}
+		if ns != raw {
+			http.Redirect(w, r, wk.pageURL("index", ns),
+				http.StatusMovedPermanently)
+			return
+		}
+	}
+	titles, err := wk.store.List()
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pages, namespaces := namespaceEntries(ns, wk.readable(r, titles))
+	wk.renderTemplate(w, "index", struct {
+		Namespace  string
+		Crumbs     []crumb
+		Pages      []crumb
+		Namespaces []crumb
+	}{ns, breadcrumbs(ns), pages, namespaces})
+}
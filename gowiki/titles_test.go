@@ -0,0 +1,43 @@
+package gowiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalTitle(t *testing.T) {
+	testcases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"FrontPage", "FrontPage", true},
+		{"Café", "Café", true},
+		{"Cafe\u0301", "Caf\u00e9", true},
+		{"Team/Runbooks/Deploy", "Team/Runbooks/Deploy", true},
+		{"/Team//Page Name/", "Team/PageName", true},
+		{"Team/../Secrets", "", false},
+		{"..", "", false},
+		{".history", "", false},
+		{"a\\b", "", false},
+		{"  ", "", false},
+	}
+	for _, tc := range testcases {
+		got, ok := canonicalTitle(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("canonicalTitle(%q) = %q, %v, want %q, %v", tc.in, got, ok,
+				tc.want, tc.ok)
+		}
+	}
+}
+
+func TestNamespaceEntries(t *testing.T) {
+	titles := []string{"FrontPage", "Team/Notes", "Team/Runbooks/Deploy",
+		"Team/Runbooks/Restore", "Zoo/Lion"}
+	pages, namespaces := namespaceEntries("Team", titles)
+	if want := []crumb{{"Notes", "Team/Notes"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf(`pages of "Team" = %v, want %v`, pages, want)
+	}
+	if want := []crumb{{"Runbooks", "Team/Runbooks"}}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf(`namespaces of "Team" = %v, want %v`, namespaces, want)
+	}
+}
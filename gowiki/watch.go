@@ -0,0 +1,180 @@
+package gowiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+)
+
+// A WatchDB holds the watchlists of the users: for each user, the titles of
+// the pages they watch, sorted. It is kept in a JSON file.
+type WatchDB struct {
+	mu    sync.Mutex
+	path  string
+	lists map[string][]string
+}
+
+// The function LoadWatchDB reads the watchlists from the file at path; a
+// missing file is just no watchlists.
+func LoadWatchDB(path string) (*WatchDB, error) {
+	db := &WatchDB{path: path, lists: make(map[string][]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &db.lists); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// The method write writes the watchlists to their file; the caller must hold
+// the lock.
+func (db *WatchDB) write() error {
+	return writeJSONFile(db.path, db.lists)
+}
+
+// The method set adds a page to the watchlist of a user, or removes it if on
+// is false.
+func (db *WatchDB) set(user, title string, on bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	list := db.lists[user]
+	i, found := slices.BinarySearch(list, title)
+	switch {
+	case on && !found:
+		db.lists[user] = slices.Insert(list, i, title)
+	case !on && found:
+		db.lists[user] = slices.Delete(list, i, i+1)
+		if len(db.lists[user]) == 0 {
+			delete(db.lists, user)
+		}
+	default:
+		return nil
+	}
+	return db.write()
+}
+
+// The method watching tells whether a user watches a page.
+func (db *WatchDB) watching(user, title string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, found := slices.BinarySearch(db.lists[user], title)
+	return found
+}
+
+// The method list returns the titles of the pages a user watches.
+func (db *WatchDB) list(user string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return slices.Clone(db.lists[user])
+}
+
+// The method watchers returns the names of the users watching a page, sorted.
+func (db *WatchDB) watchers(title string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var names []string
+	for user, list := range db.lists {
+		if _, found := slices.BinarySearch(list, title); found {
+			names = append(names, user)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// The method rename moves a page renamed from one title to another in the
+// watchlists.
+func (db *WatchDB) rename(from, to string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	changed := false
+	for user, list := range db.lists {
+		i, found := slices.BinarySearch(list, from)
+		if !found {
+			continue
+		}
+		list = slices.Delete(list, i, i+1)
+		if j, found := slices.BinarySearch(list, to); !found {
+			list = slices.Insert(list, j, to)
+		}
+		db.lists[user], changed = list, true
+	}
+	if !changed {
+		return nil
+	}
+	return db.write()
+}
+
+// The method watchHandler adds the page to the watchlist of the user, or
+// removes it, and goes back to the page; it handles URLs prefixed with
+// "/watch/". The form posts "watch" or "unwatch" in "action".
+func (wk *Wiki) watchHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	if !wk.requirePost(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
+	u := wk.currentUser(r)
+	if u == nil {
+		wk.httpError(w, "Log in to watch pages.", http.StatusForbidden)
+		return
+	}
+	var on bool
+	switch r.FormValue("action") {
+	case "watch":
+		on = true
+	case "unwatch":
+	default:
+		wk.httpError(w, "Unknown action.", http.StatusBadRequest)
+		return
+	}
+	if err := wk.watches.set(u.Name, title, on); err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, wk.pageURL("view", title), http.StatusFound)
+}
+
+// A watchedPage is a line of the watchlist: a page with its latest revision,
+// whose number is 0 if the page does not exist anymore.
+type watchedPage struct {
+	Title string
+	Revision
+}
+
+// The method watchlistHandler shows the pages the user watches, with their
+// latest revision; it handles the URL "/watchlist".
+func (wk *Wiki) watchlistHandler(w http.ResponseWriter, r *http.Request) {
+	u := wk.currentUser(r)
+	if u == nil {
+		http.Redirect(w, r, wk.basePath+"/login?next="+
+			url.QueryEscape("/watchlist"), http.StatusFound)
+		return
+	}
+	var pages []watchedPage
+	for _, title := range wk.readable(r, wk.watches.list(u.Name)) {
+		wp := watchedPage{Title: title}
+		p, err := wk.loadPage(title)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			wp.Revision = p.Revision
+		}
+		pages = append(pages, wp)
+	}
+	wk.renderTemplate(w, "watchlist", struct {
+		Pages []watchedPage
+		CSRF  string
+	}{pages, wk.csrfToken(w, r)})
+}
@@ -1,125 +1,411 @@
-package main
+package gowiki
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"regexp"
+	"time"
 )
 
 //  A wiki consists of a series of interconnected pages, each of which has a
 // title and a body (the page content). Here, we define Page as a struct with
 // two fields representing the title and body.
+// Each save of a page is kept as a revision: the Revision struct is embedded in
+// Page, so its fields (Number, Author, ...) can be used as if they were
+// declared in Page itself.
+// Meta holds the metadata written in the front matter of the body (see
+// meta.go).
 type Page struct {
 	Title string
 	Body []byte // This is a slice rather than string because that is the type
 				// expected by the io libraries we will use.
+	Revision
+	Meta Meta
 }
 
-// This method will save the Page's Body to a text file. For simplicity, we will
-// use the Title as the file name.
+// This method will save the Page's Body to the page store.
 // The save method returns the error value, to let the application handle it
-// should anything go wrong while writing the file. If all goes well,
+// should anything go wrong while writing the page. If all goes well,
 // Page.save() will return nil (the zero-value for pointers, interfaces, and
 // some other types).
-func (p *Page) save() error {
-	filename := p.Title + ".txt"
-	// The save method returns an error value because that is the return type of
-	// WriteFile (a standard library function that writes a byte slice to a
-	// file).
-	// The octal integer literal 0600, passed as the third parameter to
-	// WriteFile, indicates that the file should be created with read-write
-	// permissions for the current user only.
-    return ioutil.WriteFile(filename, p.Body, 0600)
-}
-
-// The function loadPage constructs the file name from the title parameter,
-// reads the file's contents into a new variable body, and returns a pointer to
-// a Page literal constructed with the proper title and body values.
-// Functions can return multiple values. Callers of this function can check the
-// error returned; if it is nil then it has successfully loaded a Page; if not,
-// it will be an error that can be handled by the caller.
-func loadPage(title string) (*Page, error) {
-	filename := title + ".txt"
-	// The standard library function io.ReadFile returns []byte and error.
-	body, err := ioutil.ReadFile(filename)
+// Once the page is saved, the links graph and the search index are updated
+// with the new body, and the new revision is added to the recent changes.
+func (wk *Wiki) save(p *Page) error {
+	unlock := wk.lockTitle(p.Title)
+	defer unlock()
+	return wk.commit(p)
+}
+
+// The method saveAt saves the page only if base is still the number of its
+// latest revision (0 if the page did not exist): otherwise, it returns a
+// *conflictError, so the edits made in the meantime are not lost.
+func (wk *Wiki) saveAt(p *Page, base int) error {
+	unlock := wk.lockTitle(p.Title)
+	defer unlock()
+	if _, err := wk.latestAt(p.Title, base); err != nil {
+		return err
+	}
+	return wk.commit(p)
+}
+
+// The method latestAt returns the latest revision of a page (an empty page
+// if it does not exist), or a *conflictError if its number is not base; the
+// caller must hold the lock of the title.
+func (wk *Wiki) latestAt(title string, base int) (*Page, error) {
+	latest, err := wk.loadPage(title)
+	if errors.Is(err, ErrNotFound) {
+		latest, err = &Page{Title: title}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-    return &Page{Title: title, Body: body}, nil
+	if latest.Number != base {
+		return nil, &conflictError{Base: base, Latest: latest}
+	}
+	return latest, nil
 }
 
-// The function Must is a convenience wrapper that panics when passed a non-nil
-// error value, and otherwise returns the *Template unaltered.
-// The ParseFiles function takes any number of string arguments that identify
-// our template files, and parses those files into templates that are named
-// after the base file name.
-var templates = template.Must(template.ParseFiles("edit.html", "view.html"))
+// The method deletePage deletes a page with all its revisions, provided that
+// base is the number of its latest revision (any revision if base is -1).
+func (wk *Wiki) deletePage(title string, base int) error {
+	unlock := wk.lockTitle(title)
+	defer unlock()
+	latest, err := wk.loadPage(title)
+	if err != nil {
+		return err
+	}
+	if base >= 0 && latest.Number != base {
+		return &conflictError{Base: base, Latest: latest}
+	}
+	return wk.remove(title)
+}
+
+// The method remove does the actual deleting; the caller must hold the lock on
+// the title.
+func (wk *Wiki) remove(title string) error {
+	if err := wk.store.Delete(title); err != nil {
+		return err
+	}
+	wk.links.remove(title)
+	wk.index.remove(title)
+	wk.recent.remove(title)
+	wk.tags.remove(title)
+	wk.rendered.invalidate()
+	wk.events.publish(pageEvent{Type: "delete", Title: title})
+	return nil
+}
 
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	// The method Execute executes the template, writing the generated HTML to
-	// the ResponseWriter
-	err := templates.ExecuteTemplate(w, tmpl + ".html", p)
+// The method commit does the actual saving; the caller must hold the lock on
+// the title.
+func (wk *Wiki) commit(p *Page) error {
+	// The revision replaced is read for the excerpt of the recent changes.
+	var old []byte
+	if latest, err := wk.store.Load(p.Title); err == nil {
+		old = latest.Body
+	}
+	if p.Time.IsZero() {
+		p.Time = wk.clock()
+	}
+	if err := wk.store.Save(p); err != nil {
+		return err
+	}
+	wk.links.update(p.Title, wk.pageLinks(p.Body))
+	wk.index.update(p)
+	wk.recent.add(p.Title, p.Revision, old, p.Body)
+	p.parseMeta()
+	wk.tags.update(p.Title, p.Meta["tags"])
+	// A new page changes the links to it in the other pages.
+	if p.Number == 1 {
+		wk.rendered.invalidate()
+	} else {
+		wk.rendered.invalidate(p.Title)
+	}
+	wk.events.publish(pageEvent{Type: "save", Title: p.Title,
+		Revision: p.Number, Author: p.Author, Time: p.Time})
+	wk.notifyWatchers(p)
+	return nil
+}
+
+// The method loadPage reads the page with the given title from the page
+// store, and returns a pointer to a Page with the proper title and body values.
+// Functions can return multiple values. Callers of this function can check the
+// error returned; if it is nil then it has successfully loaded a Page; if not,
+// it will be an error that can be handled by the caller (ErrNotFound if the
+// page does not exist).
+func (wk *Wiki) loadPage(title string) (*Page, error) {
+	p, err := wk.store.Load(title)
 	if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-    }
+		return nil, err
+	}
+	p.parseMeta()
+	return p, nil
 }
 
+// The templates are parsed from the files in the directory "templates" (see
+// templates.go); renderTemplate executes one of them.
+
 // Validation expression for the title.
 // The function MustCompile parses and compile the regular expression, and
 // return a Regexp. MustCompile is distinct from Compile in that it will panic
 // if the expression compilation fails, while Compile returns an error as a
 // second parameter.
-var validPath = regexp.MustCompile("^/(edit|save|view)/([a-zA-Z0-9]+)$")
+// The expression only splits the action from the title: the title is then
+// validated by canonicalTitle (see titles.go).
+var validPath = regexp.MustCompile(
+	"^/(edit|save|view|history|diff|revert|backlinks|upload|rename|delete|" +
+		"lease|unlock|events|watch)/(.+)$")
 
-// The function viewHandler allow users to view a wiki page; it will handle URLs
-// prefixed with "/view/".
-func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-	// Load the page data.
-	p, err := loadPage(title)
+// A pageView holds the data shown by the view template: the page, its body
+// rendered as HTML, the namespaces containing it, the titles of the pages
+// linking to it, the files attached to it, the user logged in, if any, the
+// title of the redirect stub the reader came from, if any, and the CSRF token
+// for the forms (see csrf.go).
+type pageView struct {
+	*Page
+	HTML           template.HTML
+	Crumbs         []crumb
+	Backlinks      []string
+	Attachments    []Attachment
+	User           *User
+	Watching       bool
+	RedirectedFrom string
+	CSRF           string
+}
+
+// The method pageExists returns a function that tells whether a page exists,
+// reading the list of the pages only once.
+func (wk *Wiki) pageExists() (func(title string) bool, error) {
+	titles, err := wk.store.List()
 	if err != nil {
+		return nil, err
+	}
+	// A map with empty struct values is the idiomatic way to represent a set.
+	set := make(map[string]struct{}, len(titles))
+	for _, title := range titles {
+		set[title] = struct{}{}
+	}
+	return func(title string) bool {
+		_, ok := set[title]
+		return ok
+	}, nil
+}
+
+// The method viewHandler allow users to view a wiki page; it will handle URLs
+// prefixed with "/view/". An older revision can be shown by giving its number
+// in the query parameter "rev".
+// The latest revision is taken from the cache of the rendered pages, if there,
+// and a client that has the page already gets a 304 Not Modified response
+// instead (see cache.go).
+func (wk *Wiki) viewHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	n, err := revisionParam(r, "rev", 0)
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The token is fresh if the request did not carry it in its cookie.
+	cookie, cerr := r.Cookie(csrfCookie)
+	csrf := wk.csrfToken(w, r)
+	fresh := cerr != nil || cookie.Value != csrf
+	version, _ := wk.rendered.state()
+	// Load the page data.
+	var p *Page
+	var html template.HTML
+	if n > 0 {
+		p, err = wk.store.LoadRevision(title, n)
+	} else if c, ok := wk.rendered.get(title); ok {
+		p, html = c.page, c.html
+	} else {
+		p, err = wk.loadPage(title)
+	}
+	if errors.Is(err, ErrNotFound) {
 		// If the requested Page doesn't exist, it redirects the client to the
 		// edit Page so the content may be created.
 		// The Redirect function adds an HTTP status code 302 and a Location
 		// header to the HTTP response.
-		http.Redirect(w, r, "/edit/" + title, http.StatusFound)
+		http.Redirect(w, r, wk.pageURL("edit", title), http.StatusFound)
 		return
 	}
-	renderTemplate(w, "view", p)
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 && wk.followRedirect(w, r, p) {
+		return
+	}
+	attachments, err := wk.store.Attachments(title)
+	if err != nil {
+		wk.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u := wk.currentUser(r)
+	watching := u != nil && wk.watches.watching(u.Name, title)
+	etag, modified := wk.viewValidators(r, p, attachments, watching, csrf)
+	if wk.notModified(w, r, etag, modified, fresh) {
+		return
+	}
+	if html == "" {
+		p.parseMeta()
+		html, err = wk.renderBody(title, p.Body)
+		if err != nil {
+			wk.httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n == 0 {
+			wk.rendered.put(title, version, renderedPage{p, html})
+		}
+	}
+	wk.renderTemplate(w, "view", pageView{Page: p,
+		HTML: html, Crumbs: breadcrumbs(title),
+		Backlinks: wk.readable(r, wk.links.backlinks(title)),
+		Attachments: attachments, User: u,
+		Watching:       watching,
+		RedirectedFrom: wikiTitle(r.FormValue("from")),
+		CSRF: csrf})
 }
 
-// The function editHandler loads the page (or, if it doesn't exist, create an
+// The method editHandler loads the page (or, if it doesn't exist, create an
 // empty Page struct), and displays an HTML form.
-func editHandler(w http.ResponseWriter, r *http.Request, title string) {
-    p, err := loadPage(title)
+// A new page starts from the page template named by the query parameter
+// "template", if any; the form offers the available ones.
+// Opening the form takes the lease on the page, or warns that somebody else
+// holds it (see lease.go).
+func (wk *Wiki) editHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+    p, err := wk.loadPage(title)
     if err != nil {
         p = &Page{Title: title}
 	}
-	renderTemplate(w, "edit", p)
+	var choices []string
+	if p.Number == 0 {
+		if body, ok := wk.templateBody(r, r.FormValue("template")); ok {
+			p.Body = body
+		}
+		choices = wk.templatePages(r)
+	}
+	data := struct {
+		*Page
+		Templates []string
+		CSRF      string
+		Lease     *lease
+		Admin     bool
+		Heartbeat int
+	}{Page: p, Templates: choices, CSRF: wk.csrfToken(w, r),
+		Heartbeat: int(leaseHeartbeat / time.Millisecond)}
+	if l, ok := wk.leases.acquire(title, wk.requestAuthor(r), wk.clock()); !ok {
+		data.Lease = &l
+		u := wk.currentUser(r)
+		data.Admin = u != nil && u.Admin
+	}
+	// The form carries the CSRF token along with the page.
+	wk.renderTemplate(w, "edit", data)
 }
 
-// The function saveHandler handles the submission of forms located on the edit
+// The method parseForm parses the form of a request carrying a page body,
+// which must not be larger than maxBodySize; if it cannot, it answers with an
+// error page and returns false.
+func (wk *Wiki) parseForm(w http.ResponseWriter, r *http.Request) bool {
+	// MaxBytesReader makes reading the body fail past the given size, so a
+	// client cannot make the wiki store pages of any size.
+	r.Body = http.MaxBytesReader(w, r.Body, wk.maxBodySize)
+	// FormValue would silently ignore the error, so the form is parsed first.
+	err := r.ParseForm()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		wk.httpError(w, fmt.Sprintf("The page is larger than %d bytes.",
+			wk.maxBodySize), http.StatusRequestEntityTooLarge)
+		return false
+	case err != nil:
+		wk.httpError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// The method saveHandler handles the submission of forms located on the edit
 // pages.
-func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+func (wk *Wiki) saveHandler(w http.ResponseWriter, r *http.Request,
+	title string) {
+	// Saving changes the page, so it must not be triggered by a simple link.
+	if !wk.requirePost(w, r) {
+		return
+	}
+	if !wk.parseForm(w, r) || !wk.checkCSRF(w, r) {
+		return
+	}
 	body := r.FormValue("body")
 	// The value returned by FormValue is of type string, so we must convert
 	// that value to []byte before it will fit into the Page struct.
-	p := &Page{Title: title, Body: []byte(body)}
-	// The save() method writes the data to a file
-	err := p.save()
+	p := &Page{Title: title, Body: []byte(body), Revision: Revision{
+		Author:  wk.requestAuthor(r),
+		Comment: r.FormValue("comment"),
+	}}
+	// The form carries the number of the revision the edit started from.
+	base, err := revisionParam(r, "base", -1)
+	if err != nil || base < 0 {
+		wk.httpError(w, "The form has a missing or invalid base revision.",
+			http.StatusBadRequest)
+		return
+	}
+	// The saveAs() method adds a new revision to the page store, unless the
+	// page was changed since the base revision; the save may also be refused,
+	// or held for moderation (see abuse.go).
+	err = wk.saveAs(p, wk.requestEditor(r), base)
+	// The function As of the errors package checks whether err is (or wraps) a
+	// *conflictError, and if so sets conflict to it.
+	var conflict *conflictError
+	if errors.As(err, &conflict) {
+		wk.conflictHandler(w, r, p, conflict)
+		return
+	}
+	if wk.refuseSave(w, err) {
+		return
+	}
 	if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        wk.httpError(w, err.Error(), http.StatusInternalServerError)
         return
     }
+	wk.leases.release(title, wk.requestAuthor(r))
 	// The client is redirected to the /view/ page.
-    http.Redirect(w, r, "/view/"+title, http.StatusFound)
+    http.Redirect(w, r, wk.pageURL("view", title), http.StatusFound)
+}
+
+// The title of the page shown at the root of the wiki.
+const frontPage = "FrontPage"
+
+// The method rootHandler sends the client to the front page; it handles the
+// URL "/" only, as the pattern "/{$}" does not match the paths below it.
+func (wk *Wiki) rootHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, wk.pageURL("view", frontPage), http.StatusFound)
+}
+
+// The level of access to a page that each action requires.
+var actionLevels = map[string]AccessLevel{
+	"view":      AccessRead,
+	"history":   AccessRead,
+	"diff":      AccessRead,
+	"backlinks": AccessRead,
+	"edit":      AccessEdit,
+	"save":      AccessEdit,
+	"revert":    AccessEdit,
+	"upload":    AccessEdit,
+	"rename":    AccessEdit,
+	"delete":    AccessAdmin,
+	"lease":     AccessEdit,
+	"unlock":    AccessAdmin,
+	"events":    AccessRead,
+	"watch":     AccessRead,
 }
 
 // The closure returned by makeHandler is a function that takes a ResponseWriter
 // and http.Request (in other words, an HandlerFunc).
-func makeHandler(fn func (http.ResponseWriter,
-	*http.Request, string)) http.HandlerFunc {
+// Before calling the handler, the closure checks that the user is allowed to
+// perform the action on the page.
+func (wk *Wiki) makeHandler(fn func (http.ResponseWriter, *http.Request,
+	string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract the page title from the Request, and call the provided
 		// handler 'fn'
@@ -127,19 +413,34 @@ func makeHandler(fn func (http.ResponseWriter,
         if m == nil {
 			// If the title is invalid, an error will be written to the
 			// ResponseWriter using the NotFound function.
-            http.NotFound(w, r)
+            wk.httpError(w, "There is no such page.", http.StatusNotFound)
             return
 		}
+		title, ok := canonicalTitle(m[2])
+		if !ok {
+			wk.httpError(w, "There is no such page.", http.StatusNotFound)
+			return
+		}
+		// A title that is valid but not in canonical form, such as
+		// "Team//Runbooks", is redirected to the canonical URL, so each page
+		// has a single address.
+		if title != m[2] {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				wk.httpError(w, "There is no such page.", http.StatusNotFound)
+				return
+			}
+			u := wk.pageURL(m[1], title)
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, u, http.StatusMovedPermanently)
+			return
+		}
+		if !wk.allow(w, r, title, actionLevels[m[1]]) {
+			return
+		}
 		// If the title is valid, the enclosed handler function fn will be
 		// called with the ResponseWriter, Request, and title as arguments.
-        fn(w, r, m[2])
+        fn(w, r, title)
 	}
 }
-
-
-func main() {
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
-    log.Fatal(http.ListenAndServe(":8080", nil))
-}